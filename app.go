@@ -15,6 +15,8 @@ import (
 var (
 	InvalidEntityError    = errors.New("Invalid entity in command")
 	InvalidReferenceError = errors.New("Invalid reference")
+	CrossAccountError     = errors.New("Cross account access not allowed")
+	InvalidAccountIDError = errors.New("Invalid account id")
 	BaseUnseted           = errors.New("Basestruct not set, should be setted")
 )
 
@@ -27,16 +29,19 @@ const (
 	EntityGroupHeader   = "X-Group"
 	SessionHeader       = "X-Session"
 	UserHeader          = "X-User"
+	AccountHeader       = "X-Account"
+	ConsistencyHeader   = "X-Consistency"
 	CookieName          = "san"
 )
 
 var runningApp *App
 
 type App struct {
-	lock    sync.Mutex
-	Version string `json:"version"`
-	Name    string `json:"name"`
-	Port    string `json:"port"`
+	lock      sync.Mutex
+	rolesLock sync.RWMutex
+	Version   string `json:"version"`
+	Name      string `json:"name"`
+	Port      string `json:"port"`
 
 	// main log stream
 	MainLog string `json:"mlog"`
@@ -47,13 +52,30 @@ type App struct {
 
 	// user roles for auth
 	Roles map[string]Role `json:"roles"`
+	// role allowed to operate on any account, using X-Account header
+	SuperAdmin string `json:"superAdmin"`
+	// role allowed to manage views
+	AdminRole string `json:"adminRole"`
+
+	// asymmetric session signing keys, if nil Secret is used
+	Keys *KeySet `json:"keys,omitempty"`
 
 	Entities map[string]*EntityConf `json:"entities"`
 	Store    EventStore             `json:"-"`
 
+	// app read models
+	Views *ViewManager `json:"-"`
+	// read models synced after every handled event
+	Readers []Reader `json:"-"`
+	// max wait for readers on strong consistency
+	SyncTimeout time.Duration `json:"syncTimeout"`
+
 	// Gin router
 	Router *gin.Engine
 
+	// entity streams being deleted, cascades skip them
+	deleting map[string]bool
+
 	// turn off auth service check
 	FirstRun        bool   `json:"firtRun"`
 	AuthOff         bool   `json:"authOff"`
@@ -75,6 +97,9 @@ func NewApp(name string, store EventStore) *App {
 	app.Endpoints = make([]Endpoint, 0)
 	app.Router = gin.New()
 	app.Store = store
+	app.Views = NewViewManager(store)
+	app.SyncTimeout = DefaultSyncTimeout
+	app.deleting = make(map[string]bool)
 	app.MainLog = strings.Replace(strings.ToLower(app.Name), " ", "_", -1) + "_log"
 	// set default session validity
 	app.SessionValidity = "300m"
@@ -98,6 +123,8 @@ func (app *App) Auth(evh ...EventHandler) {
 	}
 	userEntity.AddEventHandler(UserEventHandler{})
 	userEntity.SetBaseStruct(User{})
+	// never return secrets
+	userEntity.Hide("password", "token")
 
 	app.RegisterEntity(userEntity)
 }
@@ -109,15 +136,41 @@ func (app *App) SessionTTL(d string) {
 	}
 	app.SessionValidity = d
 	app.sduration = sd
+	if app.Keys != nil {
+		app.Keys.Grace = sd
+	}
 }
 
 func (app *App) AddRoles(roles ...Role) {
+	app.rolesLock.Lock()
+	defer app.rolesLock.Unlock()
+
 	for _, r := range roles {
+		err := r.Valid()
+		if err != nil {
+			log.Fatal(err)
+		}
+		// roles defined in code can't be changed by events
+		r.System = true
 		app.Roles[r.Name] = r
 	}
+
+	err := resolveRoles(app.Roles)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func (app *App) RegisterEntity(e *EntityConf) *App {
+	// stream names are split by separators
+	separators := "-" + TenantSeparator + GroupSeparator
+	if e.Name == "" || strings.ContainsAny(e.Name, separators) {
+		log.Fatal("Invalid entity name: " + e.Name)
+	}
+	if strings.ContainsAny(e.StreamPrefix, separators) {
+		log.Fatal("Invalid stream prefix for " + e.Name + ": " + e.StreamPrefix)
+	}
+
 	_, has := app.Entities[e.Name]
 	if !has {
 		app.Entities[e.Name] = e
@@ -128,26 +181,123 @@ func (app *App) RegisterEntity(e *EntityConf) *App {
 }
 
 func (app *App) HandleEvent(entityName, id, accid, userid, role string, ev Eventer, versionLock uint64) (string, uint64, error) {
-	var err error
+	res, err := app.handleEvent(entityName, id, accid, userid, role, ev, versionLock)
+	if err != nil {
+		return "", 0, err
+	}
+	return res.ID, res.Version, nil
+}
+
+type commandResult struct {
+	ID      string
+	Version uint64
+	// versions of streams event was linked to, views following them
+	// can be waited with View.WaitFor
+	Streams map[string]uint64
+	// gets readers sync result
+	synced <-chan error
+}
+
+func (app *App) handleEvent(entityName, id, accid, userid, role string, ev Eventer, versionLock uint64) (*commandResult, error) {
 	app.lock.Lock()
 	defer app.lock.Unlock()
+	return app.handle(entityName, id, accid, userid, role, ev, versionLock)
+}
+
+// Handle event, app lock should be held
+func (app *App) handle(entityName, id, accid, userid, role string, ev Eventer, versionLock uint64) (*commandResult, error) {
+	// entities deleted by cascade, while checking the command
+	defer func() { app.deleting = make(map[string]bool) }()
+	cmd, err := app.prepare(entityName, id, accid, userid, role, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := app.commit(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &commandResult{ID: cmd.entity.ID, Version: version}
+	res.Streams = make(map[string]uint64)
+	// app lock is held, no other event was stored since
+	for _, s := range append(ev.GetLinks(), IndexStreams(ev)...) {
+		if v, err := app.Store.Version(s); err == nil {
+			res.Streams[s] = v
+		}
+	}
+	res.synced = app.syncReaders(Scope{app, accid, cmd.group}, entityName, cmd.entity.ID)
+	return res, nil
+}
+
+// Checked command, ready to be stored
+type command struct {
+	econf  *EntityConf
+	accid  string
+	group  string
+	stream string
+	ev     Eventer
+	opt    StoreOptions
+	prior  *Entity
+	entity *Entity
+	// delete actions of referencing entities
+	actions []*command
+}
+
+// Check event against entity policies, schemas, validators and invariants.
+// Deletes check delete actions of referencing entities too. Nothing is stored.
+func (app *App) prepare(entityName, id, accid, userid, role string, ev Eventer) (*command, error) {
+	var err error
 
 	econf, ok := app.Entities[entityName]
 	if !ok {
-		return "", 0, InvalidEntityError
+		return nil, InvalidEntityError
+	}
+
+	// events are stored in account streams, linked to app log
+	// and entity correlation stream
+	group := ""
+	if e, ok := ev.(*Event); ok {
+		e.AccountID = app.tenant(econf, accid)
+		e.StreamPrefix = econf.StreamPrefix
+		e.EntityCorrelationStream = econf.CorrelationStream
+		if e.CorrelationStream == "" {
+			e.CorrelationStream = app.MainLog
+		}
+		group = e.Group
+	}
+
+	// only members can write into group streams
+	if group != "" && !app.AuthOff {
+		err = app.IsMember(accid, group, userid)
+		if err != nil {
+			return nil, PolicyError{GroupEntity, err}
+		}
 	}
 
 	// look for entity events, TODO eventstore should cache streams
-	stream := entityName + "-" + id
-	ch, _ := app.Store.Range(stream)
+	stream := app.stream(econf, accid, group, id)
+	ch, current := app.Store.Range(stream)
 	entity, err := econf.Aggregate(id, ch)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
+	entity.Group = group
+	// ranged events have no version, handlers see stream version
+	entity.Version = current
 
 	h, has := econf.EventHandlers[ev.GetType()]
 	if !has {
-		return "", 0, errors.New("Invalid handler for event:" + ev.GetType())
+		return nil, errors.New("Invalid handler for event:" + ev.GetType())
+	}
+
+	// check entity policies
+	if !app.AuthOff {
+		claims := &SessionClaims{Username: userid, Role: role, AccountID: accid}
+		err = econf.checkPolicies(claims, ev, entity)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// check base
@@ -155,75 +305,172 @@ func (app *App) HandleEvent(entityName, id, accid, userid, role string, ev Event
 		if econf.BaseSeted {
 			err = econf.checkBase(ev.GetData())
 			if err != nil {
-				return "", 0, err
+				return nil, err
 			}
 		} else {
-			return "", 0, BaseUnseted
+			return nil, BaseUnseted
+		}
+	}
+
+	// check event data schema
+	if schema, ok := econf.EventSchemas[ev.GetType()]; ok {
+		err = schema.Check(ev.GetData())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// check lifecycle transition, transition events carry next state
+	state, transition := "", false
+	if econf.Lifecycle != nil {
+		state, transition, err = econf.Lifecycle.apply(entityName, ev, entity)
+		if err != nil {
+			return nil, err
+		}
+		if transition {
+			ev.SetData(econf.Lifecycle.Field, state)
+		} else if _, set := ev.GetData()[econf.Lifecycle.Field]; set {
+			return nil, ValidationErrors{{Field: econf.Lifecycle.Field, Rule: "lifecycle", Message: "can only be changed by transitions"}}
 		}
 	}
 
 	// handler event
+	prior := copyEntity(entity)
 	opt, err := h.Handle(id, accid, userid, role, ev, entity, false)
 	if err != nil {
-		return "", 0, err
+		return nil, err
+	}
+	if transition {
+		econf.Lifecycle.set(entity, state)
+	} else if econf.Lifecycle != nil {
+		econf.Lifecycle.keep(entity, state)
 	}
 
-	// check if references exist
+	// check new references exist and are not deleted, existing ones
+	// were checked when set. Deleted entities are not checked.
+	// Every failed field is returned
+	invalid := make(ValidationErrors, 0)
 	for _, r := range econf.EntityReferences {
-		var v string
-		value := entity.Data[r.Key]
-		switch value.(type) {
-		case string:
-			v = value.(string)
-			err = app.CheckReference(r.Entity, r.Key, v, r.Null)
-			if err != nil {
-				return "", 0, err
-			}
-		case []string:
-			for _, v := range value.([]string) {
-				err = app.CheckReference(r.Entity, r.Key, v, r.Null)
-				if err != nil {
-					return "", 0, err
-				}
+		if entity.Deleted {
+			break
+		}
+		values, errs := r.values(entity)
+		invalid = append(invalid, errs...)
+
+		old, _ := r.values(prior)
+		oldIDs := referenceIDs(old)
+		for _, v := range values {
+			if contains(oldIDs, v.ID) {
+				continue
 			}
-		case nil:
-			if !r.Null {
-				return "", 0, errors.New("Invalid reference type, should be string")
+			err = app.CheckScopedReference(accid, group, r.Entity, v.Path, v.ID, r.Null)
+			if err != nil {
+				invalid = append(invalid, ValidationError{Field: v.Path, Rule: "reference", Message: err.Error()})
 			}
-		default:
-			return "", 0, errors.New("Invalid reference type, should be string")
 		}
 	}
 
 	//validate entity
+	if econf.Schema != nil {
+		err = econf.Schema.Check(entity.Data)
+		if errs, ok := err.(ValidationErrors); ok {
+			invalid = append(invalid, errs...)
+		} else if err != nil {
+			return nil, err
+		}
+	}
 	for n, v := range econf.Validators {
 		err = v.Validate(*entity)
+		if errs, ok := err.(ValidationErrors); ok {
+			invalid = append(invalid, errs...)
+		} else if err != nil {
+			return nil, errors.New("Failed validation: " + n + " - " + err.Error())
+		}
+	}
+	invalid = append(invalid, econf.checkInvariants(Scope{app, accid, group}, prior, ev, entity)...)
+	if len(invalid) > 0 {
+		return nil, invalid
+	}
+
+	cmd := &command{econf: econf, accid: accid, group: group, stream: stream, ev: ev, opt: opt, prior: prior, entity: entity}
+	// restricted references fail the delete, referencing entities
+	// are deleted or nullified once entity is deleted
+	if entity.Deleted && !prior.Deleted {
+		cmd.actions, err = app.deleteActions(econf, accid, group, entity.ID, stream, userid, role)
 		if err != nil {
-			return "", 0, errors.New("Failed validation: " + n + " - " + err.Error())
+			return nil, err
 		}
 	}
+	return cmd, nil
+}
+
+// Store checked command and its delete actions. Once the event is stored
+// the command succeeded, failures tracking references or running delete
+// actions are logged, references can be rebuilt.
+func (app *App) commit(cmd *command) (uint64, error) {
+	// reserve unique values, released if event is not stored
+	reserved, release, err := app.reserveUnique(cmd.econf, cmd.accid, cmd.stream, cmd.prior, cmd.entity)
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := app.Store.Store(cmd.ev, cmd.opt)
+	if err != nil {
+		app.releaseUnique(cmd.econf, reserved, cmd.stream)
+		return 0, err
+	}
+	app.releaseUnique(cmd.econf, release, cmd.stream)
+	err = app.trackReferences(cmd.econf, cmd.accid, cmd.stream, cmd.prior, cmd.entity)
+	if err != nil {
+		log.Println(err)
+	}
+	if cmd.econf.Name == RoleEntity {
+		app.reloadRole(cmd.entity.ID)
+	}
 
-	version, err := app.Store.Store(ev, opt)
-	return entity.ID, version, err
+	for _, a := range cmd.actions {
+		_, err = app.commit(a)
+		if err != nil {
+			log.Println("Failed to run delete action on", a.stream, ":", err)
+		}
+	}
+	return version, nil
 }
 
 // Start app
 func (app *App) Run(port string) error {
+	app.routes()
+
+	if _, ok := app.Entities[RoleEntity]; ok {
+		app.watchRoles()
+	}
+	app.Views.Start()
+
+	log.Println("-----------------------------------", "\n")
+	log.Println("Correlation stream: ", app.MainLog)
+	log.Println("-----------------------------------")
+
+	return runningApp.Router.Run(port)
+}
 
+// Register app routes, handlers serve running app
+func (app *App) routes() {
 	app.Router.GET("/up", UpHandler)
 	app.Router.POST("/event/:entity", HTTPEventHandler)
 	app.Router.GET("/docs", DocHandler)
 	app.Router.GET("/docs/:entity", EventsDocHandler)
+	app.Router.GET("/roles/:role/effective", RoleEffectiveHandler)
+	app.Router.GET("/entity/:entity", EntitiesHandler)
 	app.Router.GET("/entity/:entity/:id", EntityHandler)
 	app.Router.POST("/auth", AuthHandler)
 	app.Router.POST("/session/renew", AuthRenewHandler)
+	app.Router.GET(JWKSPath, JWKSHandler)
+	app.Router.GET("/views", ViewsHandler)
+	app.Router.POST("/unique/:entity/rebuild", UniqueRebuildHandler)
+	app.Router.POST("/references/:entity/rebuild", ReferencesRebuildHandler)
+	app.Router.POST("/indexes/rebuild", IndexesRebuildHandler)
+	app.Router.POST("/views/:name/:action", ViewActionHandler)
 	runningApp = app
-
-	log.Println("-----------------------------------", "\n")
-	log.Println("Correlation stream: ", app.MainLog)
-	log.Println("-----------------------------------")
-
-	return runningApp.Router.Run(port)
 }
 
 func UpHandler(c *gin.Context) {
@@ -231,8 +478,9 @@ func UpHandler(c *gin.Context) {
 }
 
 type SessionClaims struct {
-	Username string `json:"use"`
-	Role     string `json:"rol"`
+	Username  string `json:"use"`
+	Role      string `json:"rol"`
+	AccountID string `json:"acc"`
 	jwt.StandardClaims
 }
 
@@ -243,7 +491,17 @@ func AuthHandler(c *gin.Context) {
 	password := c.PostForm("p")
 	t := c.PostForm("t")
 
-	e, _, err := runningApp.Entity(UserEntity, username)
+	accid := c.Request.Header.Get(AccountHeader)
+	if a := c.PostForm("a"); a != "" {
+		accid = a
+	}
+	err := checkAccount(accid)
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	e, err := runningApp.AccountUser(accid, username)
 	if err != nil {
 		c.JSON(401, map[string]string{"error": ".Failed to login:" + err.Error()})
 		return
@@ -276,7 +534,11 @@ func AuthHandler(c *gin.Context) {
 		//TODO CHECK IP
 	}
 
-	tokenString := BuildToken(u)
+	tokenString, err := BuildToken(u)
+	if err != nil {
+		c.JSON(500, map[string]string{"error": "Failed to login: " + err.Error()})
+		return
+	}
 
 	c.SetCookie("cs", tokenString, -1, "/", runningApp.Domain, false, true)
 	c.JSON(200, map[string]string{"auth-token": tokenString})
@@ -309,21 +571,31 @@ func HTTPEventHandler(c *gin.Context) {
 
 	// Auth event
 	if !runningApp.AuthOff {
-		claims, err := runningApp.auth(eventType, c)
+		claims, err := runningApp.auth(entityName, eventType, c)
 		if err != nil {
 			c.JSON(401, map[string]interface{}{"error": err.Error()})
 			return
 		}
 		userid = claims.Username
 		role = claims.Role
+		accid, err = runningApp.account(claims, c)
+		if err != nil {
+			c.JSON(403, map[string]interface{}{"error": err.Error()})
+			return
+		}
 	} else {
+		accid, err = requestAccount(c)
+		if err != nil {
+			c.JSON(400, map[string]interface{}{"error": err.Error()})
+			return
+		}
 		userid = c.Request.Header.Get(UserHeader)
 		if userid == "" {
 			c.JSON(401, map[string]interface{}{"error": "Invalid noauth username"})
 			return
 		}
 		if !runningApp.FirstRun {
-			err = runningApp.CheckReference(UserEntity, "", userid, false)
+			_, err = runningApp.AccountUser(accid, userid)
 			if err != nil {
 				c.JSON(401, map[string]interface{}{"error": "Invalid noauth username does not exist, try to run as: firstrun"})
 				return
@@ -347,15 +619,42 @@ func HTTPEventHandler(c *gin.Context) {
 	event.EventID = eventID
 	event.EntityID = entityID
 	event.CorrelationStream = runningApp.MainLog
+	event.Group = c.Request.Header.Get(EntityGroupHeader)
 
 	// create event
-	id, version, err := runningApp.HandleEvent(event.Entity, event.EntityID, accid, userid, role, event, versionLock)
+	cmd, err := runningApp.handleEvent(event.Entity, event.EntityID, accid, userid, role, event, versionLock)
 	if err != nil {
+		if _, denied := err.(PolicyError); denied {
+			c.JSON(403, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if errs, invalid := err.(ValidationErrors); invalid {
+			c.JSON(422, map[string]interface{}{"errors": errs})
+			return
+		}
+		if _, referenced := err.(ReferencedError); referenced {
+			c.JSON(409, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		if _, illegal := err.(TransitionError); illegal {
+			c.JSON(409, map[string]interface{}{"error": err.Error()})
+			return
+		}
 		c.JSON(400, map[string]interface{}{"error": err.Error()})
 		return
 	}
 
-	c.JSON(201, map[string]interface{}{"entity": entityName, "entity-id": id, "version": version})
+	res := map[string]interface{}{"entity": entityName, "entity-id": cmd.ID, "version": cmd.Version, "streams": cmd.Streams}
+	// wait for readers, read your writes
+	if c.Request.Header.Get(ConsistencyHeader) == StrongConsistency {
+		err = runningApp.waitSync(cmd.synced)
+		res["consistent"] = err == nil
+		if err != nil {
+			res["syncError"] = err.Error()
+		}
+	}
+
+	c.JSON(201, res)
 	return
 }
 
@@ -368,8 +667,25 @@ func EventsDocHandler(c *gin.Context) {
 	c.JSON(200, GenerateDocs(runningApp).GetEvents(e))
 }
 
+// Effective permissions of role, admin only
+func RoleEffectiveHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+
+	r, ok := runningApp.GetRole(c.Param("role"))
+	if !ok {
+		c.JSON(404, map[string]string{"error": "Invalid role"})
+		return
+	}
+	c.JSON(200, runningApp.EffectiveRole(r))
+}
+
 func EntityHandler(c *gin.Context) {
-	//var cl *SessionClaims
+	var claims *SessionClaims
+	var accid string
 	var err error
 
 	e := c.Param("entity")
@@ -377,74 +693,241 @@ func EntityHandler(c *gin.Context) {
 
 	if !runningApp.AuthOff {
 		// I should auth read also
-		_, err = runningApp.authRead(e, c)
+		claims, err = runningApp.authRead(e, c)
 		if err != nil {
 			c.JSON(401, map[string]string{"error1": err.Error()})
 			return
 		}
 
+		accid, err = runningApp.account(claims, c)
+		if err != nil {
+			c.JSON(403, map[string]string{"error": err.Error()})
+			return
+		}
+	} else {
+		accid, err = requestAccount(c)
+		if err != nil {
+			c.JSON(400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	// group entities are only readable by members
+	group := c.Request.Header.Get(EntityGroupHeader)
+	if group != "" && !runningApp.AuthOff {
+		err = runningApp.IsMember(accid, group, claims.Username)
+		if err != nil {
+			c.JSON(403, map[string]string{"error": err.Error()})
+			return
+		}
 	}
 
 	// get entity
-	entity, _, err := runningApp.Entity(e, id)
+	entity, _, err := runningApp.ScopedEntity(accid, group, e, id)
 	if err != nil {
 		c.JSON(400, map[string]string{"error": err.Error()})
 		return
 	}
 
-	_, ok := runningApp.Entities[e]
+	econf, ok := runningApp.Entities[e]
 	if !ok {
 		c.JSON(400, map[string]string{"error": "invalid entity conf"})
 		return
 	}
 
-	c.JSON(200, entity)
+	if !runningApp.AuthOff {
+		err = econf.checkPolicies(claims, nil, entity)
+		if err != nil {
+			c.JSON(403, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, econf.Redact(entity, claims, ParseFields(c.Query(FieldsParam))))
 }
 
 func (app *App) Entity(name, id string) (*Entity, uint64, error) {
+	return app.AccountEntity("", name, id)
+}
+
+// Get entity from account streams
+func (app *App) AccountEntity(accid, name, id string) (*Entity, uint64, error) {
+	return app.ScopedEntity(accid, "", name, id)
+}
+
+// Get entity from account and group streams
+func (app *App) ScopedEntity(accid, group, name, id string) (*Entity, uint64, error) {
 	econf, ok := app.Entities[name]
 	if !ok {
 		return nil, 0, errors.New("Invalid entity name")
 	}
 
 	// look for entity events, TODO eventstore should cache streams
-	stream := name + "-" + id
+	stream := app.stream(econf, accid, group, id)
 	ch, version := app.Store.Range(stream)
 	entity, err := econf.Aggregate(id, ch)
 	if err != nil {
 		return nil, 0, err
 	}
 	entity.Version = version
+	entity.Group = group
 
 	return entity, version, err
 }
 
-func (app *App) authRole(role, eventType string) bool {
-	allowed := false
-	for _, r := range app.Roles {
-		if r.Name == role {
-			allowed = r.Can(eventType)
-			break
-		}
+// Account and group a command runs on
+type Scope struct {
+	App       *App
+	AccountID string
+	Group     string
+}
+
+// Get entity of scope, entities not in scope group are read from account
+func (s Scope) Entity(name, id string) (*Entity, uint64, error) {
+	econf, ok := s.App.Entities[name]
+	if !ok {
+		return nil, 0, errors.New("Invalid entity name")
+	}
+	group := s.App.referenceGroup(econf, s.AccountID, s.Group, id)
+	return s.App.ScopedEntity(s.AccountID, group, name, id)
+}
+
+func (app *App) authRole(role, entity, eventType string) bool {
+	r, ok := app.GetRole(role)
+	if !ok {
+		return false
 	}
-	return allowed
+	return r.CanDo(entity, eventType)
 }
 
 func (app *App) CheckReference(e, k, value string, null bool) error {
+	return app.CheckAccountReference("", e, k, value, null)
+}
+
+// Check reference exist in account, references to other accounts are not found
+func (app *App) CheckAccountReference(accid, e, k, value string, null bool) error {
+	return app.CheckScopedReference(accid, "", e, k, value, null)
+}
+
+// Check reference exist in group of referencing entity or in account,
+// entities of other groups can't be referenced.
+func (app *App) CheckScopedReference(accid, group, e, k, value string, null bool) error {
 	if value == "" && null {
 		return nil
 	}
 
 	var stream string
-	stream = e + "-" + value
+	if econf, ok := app.Entities[e]; ok {
+		group = app.referenceGroup(econf, accid, group, value)
+		stream = app.stream(econf, accid, group, value)
+	} else {
+		stream = EntityStream("", "", e, value)
+	}
 	_, err := app.Store.Version(stream)
 	if err != nil {
 		return errors.New(InvalidReferenceError.Error() + ": " + k + " - " + value + " - " + stream + " - " + err.Error())
 	}
 
+	// soft deleted entities can not be referenced
+	if _, ok := app.Entities[e]; ok {
+		entity, _, err := app.ScopedEntity(accid, group, e, value)
+		if err != nil {
+			return err
+		}
+		if entity.Deleted {
+			return errors.New(InvalidReferenceError.Error() + ": " + k + " - " + value + " - " + DeletedReferenceError.Error())
+		}
+	}
+
 	return err
 }
 
+// Group of referenced entity, group of referencing entity if
+// it's stored there, otherwise no group.
+func (app *App) referenceGroup(econf *EntityConf, accid, group, id string) string {
+	if group == "" {
+		return ""
+	}
+	_, err := app.Store.Version(app.stream(econf, accid, group, id))
+	if err != nil {
+		return ""
+	}
+	return group
+}
+
+// Stream of entity, every entity stream name should be built here
+func (app *App) stream(econf *EntityConf, accid, group, id string) string {
+	return EntityStream(app.tenant(econf, accid), group, econf.Category(), id)
+}
+
+// Every event of stream, scanned events have entity fields parsed
+// from stream names. Streams without version have no events.
+func (app *App) scan(stream string) chan Event {
+	version, err := app.Store.Version(stream)
+	if err != nil {
+		ch := make(chan Event)
+		close(ch)
+		return ch
+	}
+	return app.Store.Scan(stream, 0, version)
+}
+
+// Split entity stream name into account, group, entity name and id
+func (app *App) ParseStream(stream string) (accid, group, entity, id string) {
+	accid, group, category, id := ParseStream(stream)
+	return accid, group, app.entityName(category), id
+}
+
+// Entity name of stream category
+func (app *App) entityName(category string) string {
+	for name, econf := range app.Entities {
+		if econf.Category() == category {
+			return name
+		}
+	}
+	return category
+}
+
+// Account of entity streams, global entities have no account
+func (app *App) tenant(econf *EntityConf, accid string) string {
+	if econf.Global {
+		return ""
+	}
+	return accid
+}
+
+// Account requested by header, can't contain stream separators
+func requestAccount(c *gin.Context) (string, error) {
+	accid := c.Request.Header.Get(AccountHeader)
+	return accid, checkAccount(accid)
+}
+
+// Account ids can't contain stream separators
+func checkAccount(accid string) error {
+	if strings.ContainsAny(accid, "-"+TenantSeparator+GroupSeparator) {
+		return InvalidAccountIDError
+	}
+	return nil
+}
+
+// Resolve account for session, super admin can operate on any account
+func (app *App) account(claims *SessionClaims, c *gin.Context) (string, error) {
+	accid, err := requestAccount(c)
+	if err != nil {
+		return "", err
+	}
+
+	if accid == "" || accid == claims.AccountID {
+		return claims.AccountID, nil
+	}
+
+	if app.SuperAdmin != "" && claims.Role == app.SuperAdmin {
+		return accid, nil
+	}
+
+	return "", CrossAccountError
+}
+
 func (app *App) authRead(entity string, c *gin.Context) (*SessionClaims, error) {
 	var err error
 	t := ""
@@ -457,16 +940,14 @@ func (app *App) authRead(entity string, c *gin.Context) (*SessionClaims, error)
 	}
 
 	// create session claimsfrom token
-	token, err := jwt.ParseWithClaims(t, &SessionClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.Secret), nil
-	})
+	token, err := jwt.ParseWithClaims(t, &SessionClaims{}, app.keyFunc)
 
 	if token == nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*SessionClaims); ok && token.Valid {
-		r, exist := app.Roles[claims.Role]
+		r, exist := app.GetRole(claims.Role)
 		if !exist {
 			return claims, errors.New("Invalid role")
 		}
@@ -482,7 +963,7 @@ func (app *App) authRead(entity string, c *gin.Context) (*SessionClaims, error)
 
 }
 
-func (app *App) auth(event string, c *gin.Context) (*SessionClaims, error) {
+func (app *App) auth(entity, event string, c *gin.Context) (*SessionClaims, error) {
 	var err error
 	t := ""
 	// Read cookie
@@ -494,12 +975,12 @@ func (app *App) auth(event string, c *gin.Context) (*SessionClaims, error) {
 	}
 
 	// create session claimsfrom token
-	claims, err := AuthToken(t, app.Secret)
+	claims, err := app.AuthToken(t)
 	if err != nil {
 		return nil, err
 	}
 
-	if !app.authRole(claims.Role, event) {
+	if !app.authRole(claims.Role, entity, event) {
 		return nil, errors.New("Invalid Role, " + claims.Role)
 	}
 
@@ -518,9 +999,7 @@ func (app *App) getSession(c *gin.Context) (*SessionClaims, error) {
 	}
 
 	// create session claimsfrom token
-	token, err := jwt.ParseWithClaims(t, &SessionClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.Secret), nil
-	})
+	token, err := jwt.ParseWithClaims(t, &SessionClaims{}, app.keyFunc)
 
 	if token == nil {
 		return nil, err
@@ -0,0 +1,167 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"github.com/diegogub/gocqrs/stores"
+	"testing"
+)
+
+type item struct {
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// Memory store ranging events without entity fields, as evento store
+type plainRangeStore struct {
+	*stores.MemStore
+}
+
+func (s plainRangeStore) Range(stream string) (chan gocqrs.Eventer, uint64) {
+	ch, version := s.MemStore.Range(stream)
+	events := make(chan gocqrs.Eventer, version)
+	for e := range ch {
+		events <- gocqrs.NewEvent(e.GetId(), e.GetType(), e.GetData())
+	}
+	close(events)
+	return events, version
+}
+
+func newTestApp() (*gocqrs.App, *stores.MemStore) {
+	store := stores.NewMemStore()
+	store.Index = true
+	app := gocqrs.NewApp("test", plainRangeStore{store})
+	app.AuthOff = true
+
+	conf := gocqrs.NewEntityConf("items")
+	conf.AddCRUD(false)
+	conf.SetBaseStruct(item{})
+	app.RegisterEntity(conf)
+	return app, store
+}
+
+func handle(app *gocqrs.App, accid, eventType, id string, data map[string]interface{}) (uint64, error) {
+	ev := gocqrs.NewEvent("", eventType, data)
+	ev.Entity = "items"
+	ev.EntityID = id
+	_, version, err := app.HandleEvent("items", id, accid, "tester", "", ev, 0)
+	return version, err
+}
+
+func TestHandleEvent(t *testing.T) {
+	app, store := newTestApp()
+
+	version, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen", "price": 2.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatal("expected version 1, got", version)
+	}
+
+	version, err = handle(app, "acc", "ItemsUpdated", "i1", map[string]interface{}{"price": 3.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatal("expected version 2, got", version)
+	}
+
+	entity, v, err := app.AccountEntity("acc", "items", "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 || entity.Data["name"] != "pen" || entity.Data["price"] != 3.0 {
+		t.Fatal("unexpected entity state:", v, entity.Data)
+	}
+
+	// event linked to app log and index streams
+	for _, s := range []string{app.MainLog, gocqrs.CategoryStream("items"), gocqrs.EventTypeStream("ItemsUpdated")} {
+		if _, err := store.Version(s); err != nil {
+			t.Fatal("event not linked to", s)
+		}
+	}
+}
+
+func TestHandleEventCreateOnce(t *testing.T) {
+	app, _ := newTestApp()
+
+	_, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pencil"})
+	if err == nil {
+		t.Fatal("existing entity should not be created again")
+	}
+}
+
+func TestHandleEventInvalid(t *testing.T) {
+	app, _ := newTestApp()
+
+	_, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"color": "red"})
+	if err == nil {
+		t.Fatal("field not in base struct should fail")
+	}
+
+	_, err = handle(app, "acc", "ItemsRenamed", "i1", map[string]interface{}{"name": "pen"})
+	if err == nil {
+		t.Fatal("event without handler should fail")
+	}
+}
+
+func TestHandleEventAccounts(t *testing.T) {
+	app, _ := newTestApp()
+
+	_, err := handle(app, "acc1", "ItemsCreated", "i1", map[string]interface{}{"name": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, v, err := app.AccountEntity("acc2", "items", "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0 {
+		t.Fatal("entity of other account should not be found")
+	}
+
+	// same id into other account is a new entity
+	_, err = handle(app, "acc2", "ItemsCreated", "i1", map[string]interface{}{"name": "cup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleEventLegacyUndeleted(t *testing.T) {
+	app, store := newTestApp()
+	for _, typ := range []string{"ItemsCreated", "ItemsDeleted"} {
+		_, err := handle(app, "acc", typ, "i1", map[string]interface{}{"name": "pen"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := handle(app, "acc", "ItemsUndeleted", "i1", map[string]interface{}{})
+	if err == nil {
+		t.Fatal("legacy undelete event should not be stored")
+	}
+
+	// existing streams keep legacy events
+	ev := gocqrs.NewEvent("", "ItemsUndeleted", map[string]interface{}{})
+	ev.AccountID = "acc"
+	ev.Entity = "items"
+	ev.EntityID = "i1"
+	_, err = store.Store(ev, gocqrs.StoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, _, err := app.AccountEntity("acc", "items", "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity.Deleted {
+		t.Fatal("legacy undelete event should be replayed")
+	}
+}
@@ -23,10 +23,27 @@ func AuthToken(t, secret string) (*SessionClaims, error) {
 	}
 }
 
-func BuildToken(u User) string {
+// Parse and verify session token, using app secret or signing keys
+func (app *App) AuthToken(t string) (*SessionClaims, error) {
+	token, err := jwt.ParseWithClaims(t, &SessionClaims{}, app.keyFunc)
+
+	if token == nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*SessionClaims); ok && token.Valid {
+
+		return claims, err
+	} else {
+		return nil, err
+	}
+}
+
+func BuildToken(u User) (string, error) {
 	claims := SessionClaims{
 		u.Username,
 		u.Role,
+		u.AccountID,
 		jwt.StandardClaims{
 			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: time.Now().Add(runningApp.sduration).Unix(),
@@ -34,12 +51,23 @@ func BuildToken(u User) string {
 			Id:        lib.NewShortId(""),
 		},
 	}
+
+	if runningApp.Keys != nil {
+		k, err := runningApp.Keys.Active()
+		if err != nil {
+			return "", err
+		}
+		token := jwt.NewWithClaims(k.method(), claims)
+		token.Header["kid"] = k.ID
+
+		// Sign with active key, verifiers find public key by kid
+		return token.SignedString(k.private)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign and get the complete encoded token as a string using the secret
-	tokenString, _ := token.SignedString([]byte(runningApp.Secret))
-
-	return tokenString
+	return token.SignedString([]byte(runningApp.Secret))
 }
 
 /*
@@ -0,0 +1,43 @@
+package gocqrs
+
+import (
+	"sync"
+)
+
+// Stores view positions, next version to apply of view stream
+type CheckpointStore interface {
+	Get(view string) (uint64, error)
+	Save(view string, version uint64) error
+	Delete(view string) error
+}
+
+type MemCheckpoints struct {
+	lock        sync.RWMutex
+	checkpoints map[string]uint64
+}
+
+func NewMemCheckpoints() *MemCheckpoints {
+	var m MemCheckpoints
+	m.checkpoints = make(map[string]uint64)
+	return &m
+}
+
+func (m *MemCheckpoints) Get(view string) (uint64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.checkpoints[view], nil
+}
+
+func (m *MemCheckpoints) Save(view string, version uint64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checkpoints[view] = version
+	return nil
+}
+
+func (m *MemCheckpoints) Delete(view string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.checkpoints, view)
+	return nil
+}
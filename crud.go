@@ -16,9 +16,14 @@ const (
 var eventsNames = []string{"Created",
 	"Updated",
 	"Deleted",
-	"Undeleted",
+	"UnDeleted",
+	legacyUnDeleted,
 }
 
+// Undelete event was registered with this name, while handler only
+// applied UnDeleted. Stored events are replayed as UnDeleted.
+const legacyUnDeleted = "Undeleted"
+
 var (
 	EntityDeleted = errors.New("Entity deleted, new to undeletet to update")
 )
@@ -80,6 +85,11 @@ func (ch CRUDHandler) Handle(id string, accountid, userid, role string, ev Event
 		en.Deleted = true
 	case ch.UnDeletedEvent():
 		en.Deleted = false
+	case ch.legacyUnDeletedEvent():
+		if !replay {
+			return opt, errors.New("Event " + ev.GetType() + " is deprecated, use " + ch.UnDeletedEvent())
+		}
+		en.Deleted = false
 	}
 	return opt, err
 }
@@ -124,3 +134,7 @@ func (ch CRUDHandler) DeletedEvent() string {
 func (ch CRUDHandler) UnDeletedEvent() string {
 	return strings.Title(ch.EntityName) + "UnDeleted"
 }
+
+func (ch CRUDHandler) legacyUnDeletedEvent() string {
+	return strings.Title(ch.EntityName) + legacyUnDeleted
+}
@@ -10,7 +10,23 @@ type APPDocs struct {
 	Name      string              `json:"name"`
 	Version   string              `json:"version"`
 	Entities  map[string][]string `json:"entities"`
+	Roles     map[string][]string `json:"roles"`
 	Endpoints []Endpoint          `json:"endpoints"`
+	// entity and event JSON schemas
+	Schemas map[string]EntitySchemas `json:"schemas"`
+	// entity state machines
+	Lifecycles map[string]LifecycleDocs `json:"lifecycles"`
+}
+
+type LifecycleDocs struct {
+	Machine *StateMachine `json:"machine"`
+	// Graphviz DOT diagram
+	Dot string `json:"dot"`
+}
+
+type EntitySchemas struct {
+	Entity *Schema            `json:"entity,omitempty"`
+	Events map[string]*Schema `json:"events,omitempty"`
 }
 
 type Endpoint struct {
@@ -59,6 +75,9 @@ func (app *App) AddEndpoint(e Endpoint) {
 func (app *App) GenDocs() APPDocs {
 	var docs APPDocs
 	docs.Entities = make(map[string][]string)
+	docs.Roles = make(map[string][]string)
+	docs.Schemas = make(map[string]EntitySchemas)
+	docs.Lifecycles = make(map[string]LifecycleDocs)
 	docs.Name = app.Name
 	docs.Version = app.Version
 
@@ -67,7 +86,22 @@ func (app *App) GenDocs() APPDocs {
 		for event, _ := range c.EventHandlers {
 			docs.Entities[e] = append(docs.Entities[e], event)
 		}
+
+		if c.Schema != nil || len(c.EventSchemas) > 0 {
+			docs.Schemas[e] = EntitySchemas{c.Schema, c.EventSchemas}
+		}
+
+		if c.Lifecycle != nil {
+			docs.Lifecycles[e] = LifecycleDocs{c.Lifecycle, c.Lifecycle.Dot(e)}
+		}
+	}
+
+	// role hierarchy, role -> parents
+	app.rolesLock.RLock()
+	for name, r := range app.Roles {
+		docs.Roles[name] = append([]string{}, r.Parents...)
 	}
+	app.rolesLock.RUnlock()
 
 	docs.Endpoints = app.Endpoints
 
@@ -19,8 +19,11 @@ type EntityConf struct {
 	CRUD bool `json:"crud"`
 
 	Description string `json:"desc"`
-	// basic entity prefix
-	StreamPrefix      string `json:"stream_prefix"`
+	// global entities are shared by all accounts, streams are not prefixed
+	Global bool `json:"global"`
+	// basic entity prefix, streams are named prefix+name-id
+	StreamPrefix string `json:"stream_prefix"`
+	// entity events are also linked to this stream, if not empty
 	CorrelationStream string `json:"correlation_stream"`
 
 	// Entity ID references
@@ -30,10 +33,25 @@ type EntityConf struct {
 	EventHandlers map[string]EventHandler `json:"handlers"`
 
 	Validators map[string]Validator `json:"validators"`
-	BaseStruct interface{}          `json:"base,omitempty"`
+	Policies   map[string]Policy    `json:"policies"`
+	Invariants map[string]Invariant `json:"invariants"`
+	Lifecycle  *StateMachine        `json:"lifecycle,omitempty"`
+	UniqueKeys []UniqueKey          `json:"unique,omitempty"`
+	// JSON schemas of entity state and event data
+	Schema       *Schema            `json:"schema,omitempty"`
+	EventSchemas map[string]*Schema `json:"eventSchemas,omitempty"`
+
+	BaseStruct interface{} `json:"base,omitempty"`
 	BaseSeted  bool
 
 	ReadRoles []string `json:"roles,omitempty"`
+
+	// read masks
+	HiddenFields []string            `json:"hidden,omitempty"`
+	RoleFields   map[string][]string `json:"roleFields,omitempty"`
+
+	// read model listing entities
+	Projection Projection `json:"-"`
 }
 
 type BasicEntity struct {
@@ -49,6 +67,8 @@ type EntityReference struct {
 	Entity string `json:"entity"`
 	Key    string `json:"key"`
 	Null   bool   `json:"null"`
+	// action when referenced entity is deleted
+	OnDelete string `json:"onDelete,omitempty"`
 }
 
 func NewEntityConf(name string) *EntityConf {
@@ -56,12 +76,20 @@ func NewEntityConf(name string) *EntityConf {
 	name = strings.ToLower(name)
 	e.Name = name
 	e.Validators = make(map[string]Validator)
+	e.Policies = make(map[string]Policy)
+	e.Invariants = make(map[string]Invariant)
+	e.EventSchemas = make(map[string]*Schema)
 	e.EventHandlers = make(map[string]EventHandler)
 	return &e
 }
 
+// Stream category of entity
+func (e *EntityConf) Category() string {
+	return e.StreamPrefix + e.Name
+}
+
 func (e *EntityConf) Reference(en, k string, null bool) {
-	e.EntityReferences = append(e.EntityReferences, EntityReference{en, k, null})
+	e.ReferenceOnDelete(en, k, null, NoAction)
 }
 
 func (e *EntityConf) AddValidator(v ...Validator) error {
@@ -130,8 +158,20 @@ func (ec *EntityConf) Aggregate(id string, events chan Eventer) (*Entity, error)
 		}
 
 		if e.GetVersion() == entity.Version+1 || e.GetVersion() == 0 {
+			// stored events were legal, state is only moved
+			state, transition := "", false
+			if ec.Lifecycle != nil {
+				state, transition, _ = ec.Lifecycle.apply(ec.Name, e, &entity)
+			}
+
 			// replay do not have userid or role
 			_, err = eventHandler.Handle(id, "", "", "", e, &entity, true)
+
+			if transition && state != NewState {
+				ec.Lifecycle.set(&entity, state)
+			} else if ec.Lifecycle != nil && !transition {
+				ec.Lifecycle.keep(&entity, state)
+			}
 		} else {
 			return &entity, errors.New("Failed to aggregate entity " + id + " , unorder events")
 		}
@@ -152,8 +192,12 @@ func (ev EntityConf) checkBase(data map[string]interface{}) error {
 	for k, _ := range data {
 		has := false
 		for f := 0; f < n; f++ {
-			v, hasTag := t.Field(f).Tag.Lookup("json")
-			if !hasTag {
+			// tag name, without options like omitempty
+			v := strings.Split(t.Field(f).Tag.Get("json"), ",")[0]
+			if v == "-" {
+				continue
+			}
+			if v == "" {
 				v = t.Field(f).Name
 			}
 			if v == k {
@@ -165,14 +209,13 @@ func (ev EntityConf) checkBase(data map[string]interface{}) error {
 		if !has {
 			return errors.New("Invalid field, do not exist in base struct: " + k)
 		}
+	}
 
-		// check json decode
-		b, _ := json.Marshal(data)
-		log.Println(string(b), i)
-		err := json.Unmarshal(b, &i)
-		if err != nil {
-			return errors.New("Invalid type:" + err.Error())
-		}
+	// check json decode
+	b, _ := json.Marshal(data)
+	err := json.Unmarshal(b, reflect.New(t).Interface())
+	if err != nil {
+		return errors.New("Invalid type:" + err.Error())
 	}
 
 	return nil
@@ -3,9 +3,16 @@ package gocqrs
 import (
 	"encoding/json"
 	"github.com/diegogub/lib"
+	"strings"
 	"time"
 )
 
+const (
+	// separates account and group from entity stream, acc.group:entity-id
+	TenantSeparator = "."
+	GroupSeparator  = ":"
+)
+
 type Eventer interface {
 	GetId() string
 	GetStream() string
@@ -28,12 +35,16 @@ type BaseEvent struct {
 
 type Event struct {
 	BaseEvent
-	Group             string                 `json:"group,omitempty"`
-	Entity            string                 `json:"ent,omitepty"`
-	CorrelationStream string                 `json:"cid,omitempty"`
-	EntityID          string                 `json:"id,omitempty"`
-	StreamPrefix      string                 `json:"streamPre,omitempty"`
-	EventData         map[string]interface{} `json:"data,omitempty"`
+	Group                   string                 `json:"group,omitempty"`
+	AccountID               string                 `json:"acc,omitempty"`
+	Entity                  string                 `json:"ent,omitepty"`
+	CorrelationStream       string                 `json:"cid,omitempty"`
+	EntityCorrelationStream string                 `json:"ecid,omitempty"`
+	EntityID                string                 `json:"id,omitempty"`
+	StreamPrefix            string                 `json:"streamPre,omitempty"`
+	EventData               map[string]interface{} `json:"data,omitempty"`
+	// version into entity stream, EventVersion is version into read stream
+	EntityVersion uint64 `json:"ever,omitempty"`
 }
 
 func NewEvent(id, t string, data map[string]interface{}) *Event {
@@ -68,15 +79,56 @@ func (e *Event) Has(k string) bool {
 }
 
 func (e *Event) GetLinks() []string {
-	return []string{e.CorrelationStream}
+	links := make([]string, 0)
+	for _, l := range []string{e.CorrelationStream, e.EntityCorrelationStream} {
+		if l != "" {
+			links = append(links, l)
+		}
+	}
+	return links
 }
 
 func (e *Event) GetStream() string {
-	if e.Group == "" {
-		return e.Entity + "-" + e.EntityID
-	} else {
-		return e.Group + "-" + e.Entity + "-" + e.EntityID
+	return EntityStream(e.AccountID, e.Group, e.StreamPrefix+e.Entity, e.EntityID)
+}
+
+// Stream name of entity, prefixed with account and group if not empty.
+// Category is entity name with its stream prefix, see EntityConf.Category
+func EntityStream(accid, group, category, id string) string {
+	stream := category + "-" + id
+	if group != "" {
+		stream = group + GroupSeparator + stream
+	}
+	if accid != "" {
+		stream = accid + TenantSeparator + stream
+	}
+	return stream
+}
+
+// Split entity stream name into account, group, category and id,
+// category is entity name with its stream prefix (see App.ParseStream)
+func ParseStream(stream string) (accid, group, category, id string) {
+	parts := strings.SplitN(stream, "-", 2)
+	category = parts[0]
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+
+	if i := strings.LastIndex(category, GroupSeparator); i >= 0 {
+		group = category[:i]
+		category = category[i+len(GroupSeparator):]
+	}
+
+	// account prefixes group if any, else category
+	prefix := &category
+	if group != "" {
+		prefix = &group
+	}
+	if i := strings.Index(*prefix, TenantSeparator); i >= 0 {
+		accid = (*prefix)[:i]
+		*prefix = (*prefix)[i+len(TenantSeparator):]
 	}
+	return accid, group, category, id
 }
 
 func (e *Event) GetType() string {
@@ -0,0 +1,6 @@
+package gocqrs
+
+// Register app routes without running it
+func (app *App) Routes() {
+	app.routes()
+}
@@ -1,13 +1,158 @@
 package gocqrs
 
-import ()
+import (
+	"errors"
+	"strings"
+)
 
 const (
 	GroupEntity = "groups"
+
+	GroupCreatedEvent       = "GroupCreated"
+	GroupMemberAddedEvent   = "GroupMemberAdded"
+	GroupMemberRemovedEvent = "GroupMemberRemoved"
+	GroupDeletedEvent       = "GroupDeleted"
+)
+
+var (
+	NotMemberError      = errors.New("User is not member of group")
+	GroupsDisabledError = errors.New("Groups not enabled")
+	InvalidGroupIDError = errors.New("Invalid group id")
 )
 
 type Group struct {
 	BasicEntity
-	ID   string            `json:"id"`
-	Data map[string]string `json:"data"`
+	ID      string            `json:"id"`
+	Members []string          `json:"members"`
+	Data    map[string]string `json:"data"`
+}
+
+func (g *Group) IsMember(userid string) bool {
+	return contains(g.Members, userid)
+}
+
+// Handles group membership, only members can change a group.
+// GroupMemberAdded and GroupMemberRemoved carry {"member": userid}
+type GroupEventHandler struct {
+}
+
+func (gh GroupEventHandler) EventName() []string {
+	return []string{
+		GroupCreatedEvent,
+		GroupMemberAddedEvent,
+		GroupMemberRemovedEvent,
+		GroupDeletedEvent,
+	}
+}
+
+func (gh GroupEventHandler) Handle(id, accid, userid, role string, event Eventer, entity *Entity, replay bool) (StoreOptions, error) {
+	var opt StoreOptions
+	var err error
+	var g Group
+
+	if event.GetType() == GroupCreatedEvent {
+		if strings.ContainsAny(id, TenantSeparator+GroupSeparator+"-") {
+			return opt, InvalidGroupIDError
+		}
+		DecodeEvent(event, &g)
+		g.ID = id
+		opt.Create = true
+		if !replay {
+			// creator is first member
+			if !g.IsMember(userid) {
+				g.Members = append(g.Members, userid)
+			}
+			event.SetData("members", g.Members)
+		}
+		entity.Data = ToMap(g)
+		return opt, err
+	}
+
+	entity.Decode(&g)
+	if !replay {
+		if entity.Deleted {
+			return opt, EntityDeleted
+		}
+		if !g.IsMember(userid) {
+			return opt, NotMemberError
+		}
+	}
+
+	member, _ := event.GetData()["member"].(string)
+	switch event.GetType() {
+	case GroupMemberAddedEvent:
+		if member == "" {
+			return opt, errors.New("Invalid member")
+		}
+		if !g.IsMember(member) {
+			g.Members = append(g.Members, member)
+		}
+	case GroupMemberRemovedEvent:
+		members := make([]string, 0)
+		for _, m := range g.Members {
+			if m != member {
+				members = append(members, m)
+			}
+		}
+		g.Members = members
+	case GroupDeletedEvent:
+		entity.Deleted = true
+	}
+	entity.Data = ToMap(g)
+
+	return opt, err
+}
+
+func (gh GroupEventHandler) CheckBase(e Eventer) bool {
+	switch e.GetType() {
+	case GroupCreatedEvent:
+		return true
+	}
+	return false
+}
+
+// Add groups functionality, entities can be stored into group streams
+// using X-Group header, only group members can write or read them.
+func (app *App) Groups(evh ...EventHandler) {
+	groupEntity := NewEntityConf(GroupEntity)
+
+	for _, h := range evh {
+		groupEntity.AddEventHandler(h)
+	}
+	groupEntity.AddEventHandler(GroupEventHandler{})
+	groupEntity.SetBaseStruct(Group{})
+
+	app.RegisterEntity(groupEntity)
+}
+
+// Check user is member of group, listed into group members or
+// group is listed into user groups
+func (app *App) IsMember(accid, group, userid string) error {
+	if _, ok := app.Entities[GroupEntity]; !ok {
+		return GroupsDisabledError
+	}
+
+	var g Group
+	e, _, err := app.AccountEntity(accid, GroupEntity, group)
+	if err != nil {
+		return err
+	}
+	e.Decode(&g)
+	if e.Deleted || g.ID == "" {
+		return NotMemberError
+	}
+	if g.IsMember(userid) {
+		return nil
+	}
+
+	var u User
+	e, err = app.AccountUser(accid, userid)
+	if err == nil {
+		e.Decode(&u)
+		if contains(u.Groups, group) {
+			return nil
+		}
+	}
+
+	return NotMemberError
 }
@@ -0,0 +1,86 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Serve request with app routes, form is sent as body if not nil.
+// Routes should be registered.
+func serve(app *gocqrs.App, method, path string, form url.Values, header map[string]string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, req)
+	return w
+}
+
+// Serve request with JSON body, routes should be registered
+func serveJSON(app *gocqrs.App, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, req)
+	return w
+}
+
+func TestInvalidAccount(t *testing.T) {
+	for _, accid := range []string{"a-b", "a.b", "a:b"} {
+		app, _ := newTestApp()
+		app.Routes()
+		w := serve(app, "GET", "/entity/items/i1", nil, map[string]string{gocqrs.AccountHeader: accid})
+		if w.Code != 400 {
+			t.Fatal("expected 400 for account", accid, "got", w.Code)
+		}
+
+		app, _ = newTestApp()
+		app.Auth()
+		app.Routes()
+		w = serve(app, "POST", "/auth", url.Values{"u": {"admin"}, "p": {"secret"}, "a": {accid}}, nil)
+		if w.Code != 400 {
+			t.Fatal("expected 400 on login for account", accid, "got", w.Code)
+		}
+	}
+}
+
+func TestIndexesRebuild(t *testing.T) {
+	app, store := newTestApp()
+	app.Routes()
+	if _, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen", "price": 2.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(app, "POST", "/indexes/rebuild", nil, nil)
+	if w.Code != 200 {
+		t.Fatal("expected 200, got", w.Code, w.Body.String())
+	}
+	if v, _ := store.Version(gocqrs.CategoryStream("items")); v != 1 {
+		t.Fatal("expected items index relinked, got", v)
+	}
+
+	app, _ = newTestApp()
+	app.AuthOff = false
+	app.Auth()
+	app.Routes()
+	w = serve(app, "POST", "/indexes/rebuild", nil, nil)
+	if w.Code != 401 {
+		t.Fatal("expected 401 without admin session, got", w.Code)
+	}
+}
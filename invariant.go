@@ -0,0 +1,80 @@
+package gocqrs
+
+import (
+	"log"
+	"sort"
+)
+
+// Invariant checks rules over a state change, prior is the entity before
+// the event, with version 0 for new entities, and entity the state after it.
+// Invariants run while the app lock is held, other entities of command
+// account and group can be read with Scope.Entity. Failures are returned
+// as ValidationErrors.
+type Invariant interface {
+	GetName() string
+	Check(s Scope, prior *Entity, event Eventer, entity *Entity) error
+}
+
+type invariantFunc struct {
+	name  string
+	check func(s Scope, prior *Entity, event Eventer, entity *Entity) error
+}
+
+func (i invariantFunc) GetName() string {
+	return i.name
+}
+
+func (i invariantFunc) Check(s Scope, prior *Entity, event Eventer, entity *Entity) error {
+	return i.check(s, prior, event, entity)
+}
+
+// Invariant from function
+func NewInvariant(name string, check func(s Scope, prior *Entity, event Eventer, entity *Entity) error) Invariant {
+	return invariantFunc{name, check}
+}
+
+func (e *EntityConf) AddInvariant(inv ...Invariant) {
+	if e.Invariants == nil {
+		e.Invariants = make(map[string]Invariant)
+	}
+
+	for _, i := range inv {
+		_, exist := e.Invariants[i.GetName()]
+		if exist {
+			log.Fatal("Could not add invariant, already set:" + i.GetName())
+		}
+		e.Invariants[i.GetName()] = i
+	}
+}
+
+// Run every invariant, by name order
+func (e *EntityConf) checkInvariants(s Scope, prior *Entity, event Eventer, entity *Entity) ValidationErrors {
+	names := make([]string, 0, len(e.Invariants))
+	for n, _ := range e.Invariants {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	errs := make(ValidationErrors, 0)
+	for _, n := range names {
+		err := e.Invariants[n].Check(s, prior, event, entity)
+		switch ie := err.(type) {
+		case nil:
+		case ValidationErrors:
+			for _, ve := range ie {
+				if ve.Rule == "" {
+					ve.Rule = n
+				}
+				errs = append(errs, ve)
+			}
+		case ValidationError:
+			if ie.Rule == "" {
+				ie.Rule = n
+			}
+			errs = append(errs, ie)
+		default:
+			errs = append(errs, ValidationError{Rule: n, Message: err.Error()})
+		}
+	}
+	return errs
+}
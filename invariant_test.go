@@ -0,0 +1,66 @@
+package gocqrs_test
+
+import (
+	"errors"
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+type order struct {
+	Customer string  `json:"customer"`
+	Total    float64 `json:"total"`
+}
+
+type customer struct {
+	Name  string  `json:"name"`
+	Limit float64 `json:"limit"`
+}
+
+func TestInvariantScope(t *testing.T) {
+	app, _ := newTestApp()
+	customers := gocqrs.NewEntityConf("customers")
+	customers.AddCRUD(false)
+	customers.SetBaseStruct(customer{})
+	app.RegisterEntity(customers)
+
+	orders := gocqrs.NewEntityConf("orders")
+	orders.AddCRUD(false)
+	orders.SetBaseStruct(order{})
+	orders.AddInvariant(gocqrs.NewInvariant("creditLimit", func(s gocqrs.Scope, prior *gocqrs.Entity, ev gocqrs.Eventer, entity *gocqrs.Entity) error {
+		c, version, err := s.Entity("customers", entity.Data["customer"].(string))
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			return errors.New("customer not found")
+		}
+		if entity.Data["total"].(float64) > c.Data["limit"].(float64) {
+			return errors.New("total exceeds customer credit limit")
+		}
+		return nil
+	}))
+	app.RegisterEntity(orders)
+
+	send := func(entity, eventType, id string, data map[string]interface{}) error {
+		ev := gocqrs.NewEvent("", eventType, data)
+		ev.Entity = entity
+		ev.EntityID = id
+		_, _, err := app.HandleEvent(entity, id, "acc", "tester", "", ev, 0)
+		return err
+	}
+
+	err := send("customers", "CustomersCreated", "c1", map[string]interface{}{"name": "ann", "limit": 100.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = send("orders", "OrdersCreated", "o1", map[string]interface{}{"customer": "c1", "total": 50.0})
+	if err != nil {
+		t.Fatal("order of account customer should be valid:", err)
+	}
+
+	err = send("orders", "OrdersCreated", "o2", map[string]interface{}{"customer": "c1", "total": 150.0})
+	if _, ok := err.(gocqrs.ValidationErrors); !ok {
+		t.Fatal("expected validation errors, got", err)
+	}
+}
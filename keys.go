@@ -0,0 +1,329 @@
+package gocqrs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/diegogub/lib"
+	"gopkg.in/gin-gonic/gin.v1"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+
+	JWKSPath = "/.well-known/jwks.json"
+)
+
+var (
+	InvalidAlgError  = errors.New("Invalid signing algorithm, should be RS256 or ES256")
+	InvalidKeyError  = errors.New("Invalid signing key")
+	UnknownKeyError  = errors.New("Unknown or retired key id")
+	NoActiveKeyError = errors.New("No active signing key")
+)
+
+// Asymmetric key used to sign and verify sessions
+type SigningKey struct {
+	ID      string    `json:"kid"`
+	Alg     string    `json:"alg"`
+	Created time.Time `json:"created"`
+	// key is not used to verify sessions after this time, zero means never
+	RetireAt time.Time `json:"retireAt,omitempty"`
+
+	private crypto.Signer
+}
+
+func (k *SigningKey) Retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	switch k.Alg {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case ES256:
+		return jwt.SigningMethodES256
+	}
+	return nil
+}
+
+// JWK returns the public part of the key, as published in jwks.json
+func (k *SigningKey) JWK() map[string]string {
+	jwk := map[string]string{
+		"kid": k.ID,
+		"alg": k.Alg,
+		"use": "sig",
+	}
+
+	switch pub := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = b64(pub.N.Bytes())
+		jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = b64(padBytes(pub.X.Bytes(), size))
+		jwk["y"] = b64(padBytes(pub.Y.Bytes(), size))
+	}
+
+	return jwk
+}
+
+// Set of signing keys, only one is active to sign new sessions,
+// any other non retired key is still valid to verify them.
+type KeySet struct {
+	lock   sync.RWMutex
+	Alg    string `json:"alg"`
+	active string
+	keys   map[string]*SigningKey
+
+	// time a rotated key keeps verifying sessions
+	Grace time.Duration `json:"grace"`
+}
+
+// Key set with a generated active key. Generated keys only live in memory,
+// sessions are not valid after a restart nor on other replicas, see NewPEMKeySet.
+func NewKeySet(alg string, grace time.Duration) (*KeySet, error) {
+	ks, err := newKeySet(alg, grace)
+	if err != nil {
+		return nil, err
+	}
+	return ks, ks.Rotate()
+}
+
+// Key set with existing private key in PEM format as active key, replicas
+// sharing the key verify each other sessions.
+func NewPEMKeySet(alg string, grace time.Duration, kid string, pem []byte) (*KeySet, error) {
+	ks, err := newKeySet(alg, grace)
+	if err != nil {
+		return nil, err
+	}
+	return ks, ks.AddPEM(kid, pem)
+}
+
+func newKeySet(alg string, grace time.Duration) (*KeySet, error) {
+	var ks KeySet
+	switch alg {
+	case RS256, ES256:
+	default:
+		return nil, InvalidAlgError
+	}
+
+	ks.Alg = alg
+	ks.Grace = grace
+	ks.keys = make(map[string]*SigningKey)
+	return &ks, nil
+}
+
+// Add existing private key in PEM format, it becomes the active key
+func (ks *KeySet) AddPEM(kid string, pem []byte) error {
+	var signer crypto.Signer
+	var err error
+
+	switch ks.Alg {
+	case RS256:
+		signer, err = jwt.ParseRSAPrivateKeyFromPEM(pem)
+	case ES256:
+		signer, err = jwt.ParseECPrivateKeyFromPEM(pem)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ks.add(kid, signer)
+}
+
+// Generate new active key, previous active key is retired after grace period.
+// Generated keys are not shared, replicas should rotate with AddPEM.
+func (ks *KeySet) Rotate() error {
+	var signer crypto.Signer
+	var err error
+
+	switch ks.Alg {
+	case RS256:
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	return ks.add(lib.NewShortId("k"), signer)
+}
+
+func (ks *KeySet) add(kid string, signer crypto.Signer) error {
+	if kid == "" || signer == nil {
+		return InvalidKeyError
+	}
+
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	now := time.Now().UTC()
+	if current, ok := ks.keys[ks.active]; ok {
+		current.RetireAt = now.Add(ks.Grace)
+	}
+
+	ks.keys[kid] = &SigningKey{
+		ID:      kid,
+		Alg:     ks.Alg,
+		Created: now,
+		private: signer,
+	}
+	ks.active = kid
+
+	return nil
+}
+
+// Remove keys that are already retired
+func (ks *KeySet) Clean() {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	now := time.Now().UTC()
+	for kid, k := range ks.keys {
+		if kid != ks.active && k.Retired(now) {
+			delete(ks.keys, kid)
+		}
+	}
+}
+
+// Rotate keys every d, until app dies
+func (ks *KeySet) RotateEvery(d time.Duration) {
+	go func() {
+		for {
+			time.Sleep(d)
+			err := ks.Rotate()
+			if err != nil {
+				log.Println("Failed to rotate signing key:", err)
+			}
+			ks.Clean()
+		}
+	}()
+}
+
+// Active key, used to sign new sessions
+func (ks *KeySet) Active() (*SigningKey, error) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	k, ok := ks.keys[ks.active]
+	if !ok {
+		return nil, NoActiveKeyError
+	}
+	return k, nil
+}
+
+// Get non retired key by kid
+func (ks *KeySet) Key(kid string) (*SigningKey, error) {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	k, ok := ks.keys[kid]
+	if !ok || k.Retired(time.Now().UTC()) {
+		return nil, UnknownKeyError
+	}
+	return k, nil
+}
+
+// Public keys of every non retired key
+func (ks *KeySet) JWKS() map[string][]map[string]string {
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+
+	now := time.Now().UTC()
+	keys := make([]map[string]string, 0)
+	for _, k := range ks.keys {
+		if !k.Retired(now) {
+			keys = append(keys, k.JWK())
+		}
+	}
+
+	return map[string][]map[string]string{"keys": keys}
+}
+
+// Sign sessions with asymmetric keys instead of secret,
+// keys are rotated every rotate duration if not empty. Keys only live in
+// memory, sessions are lost on restart, replicas should use SigningKeysPEM.
+func (app *App) SigningKeys(alg, rotate string) {
+	ks, err := NewKeySet(alg, app.sduration)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if rotate != "" {
+		d, err := time.ParseDuration(rotate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ks.RotateEvery(d)
+	}
+
+	app.Keys = ks
+}
+
+// Sign sessions with shared private key in PEM format, every replica
+// loading the key verifies sessions of the others and after restarts.
+// Keys are rotated by adding the new key with Keys.AddPEM on every replica.
+func (app *App) SigningKeysPEM(alg, kid string, pem []byte) {
+	ks, err := NewPEMKeySet(alg, app.sduration, kid, pem)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Keys = ks
+}
+
+// Returns key to verify token, depending on app signing mode
+func (app *App) keyFunc(token *jwt.Token) (interface{}, error) {
+	if app.Keys == nil {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, InvalidAlgError
+		}
+		return []byte(app.Secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	k, err := app.Keys.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != k.Alg {
+		return nil, InvalidAlgError
+	}
+
+	return k.private.Public(), nil
+}
+
+func JWKSHandler(c *gin.Context) {
+	if runningApp.Keys == nil {
+		c.JSON(200, map[string][]map[string]string{"keys": []map[string]string{}})
+		return
+	}
+	c.JSON(200, runningApp.Keys.JWKS())
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
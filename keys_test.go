@@ -0,0 +1,208 @@
+package gocqrs_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/diegogub/gocqrs"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Session token signed by app, app routes should be registered
+func sessionToken(t *testing.T, app *gocqrs.App) string {
+	app.Routes()
+	token, err := gocqrs.BuildToken(gocqrs.User{Username: "ann", Role: "member", AccountID: "acc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestSigningKeys(t *testing.T) {
+	for _, alg := range []string{gocqrs.RS256, gocqrs.ES256} {
+		app, _ := newTestApp()
+		app.Secret = "secret"
+		app.SigningKeys(alg, "")
+		token := sessionToken(t, app)
+
+		claims, err := app.AuthToken(token)
+		if err != nil || claims.Username != "ann" {
+			t.Fatal(alg, "expected valid session, got", claims, err)
+		}
+
+		// other app keys can't verify session
+		other, _ := newTestApp()
+		other.SigningKeys(alg, "")
+		if _, err = other.AuthToken(token); err == nil {
+			t.Fatal(alg, "session verified with other keys")
+		}
+
+		// secret signed sessions are rejected
+		hs := jwt.NewWithClaims(jwt.SigningMethodHS256, gocqrs.SessionClaims{Username: "ann"})
+		signed, err := hs.SignedString([]byte(app.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = app.AuthToken(signed); err == nil {
+			t.Fatal(alg, "secret signed session verified")
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ks, err := gocqrs.NewKeySet(gocqrs.ES256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ks.Active()
+
+	err = ks.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, _ := ks.Active()
+	if active.ID == first.ID {
+		t.Fatal("rotated key should be active")
+	}
+
+	// rotated key verifies during grace
+	if _, err = ks.Key(first.ID); err != nil {
+		t.Fatal("rotated key should verify during grace:", err)
+	}
+	if len(ks.JWKS()["keys"]) != 2 {
+		t.Fatal("expected rotated and active keys published")
+	}
+
+	// retired key is rejected, not published and cleaned
+	ks.Grace = 0
+	err = ks.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ks.Key(active.ID); err != gocqrs.UnknownKeyError {
+		t.Fatal("expected retired key rejected, got", err)
+	}
+	for _, jwk := range ks.JWKS()["keys"] {
+		if jwk["kid"] == active.ID {
+			t.Fatal("retired key published")
+		}
+	}
+	ks.Clean()
+	if _, err = ks.Key(first.ID); err != nil {
+		t.Fatal("key in grace should not be cleaned:", err)
+	}
+	if _, err = ks.Key("missing"); err != gocqrs.UnknownKeyError {
+		t.Fatal("expected unknown key, got", err)
+	}
+}
+
+func TestRetiredKeySession(t *testing.T) {
+	app, _ := newTestApp()
+	app.SigningKeys(gocqrs.ES256, "")
+	token := sessionToken(t, app)
+
+	app.Keys.Grace = 0
+	err := app.Keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.AuthToken(token); err == nil {
+		t.Fatal("session signed with retired key verified")
+	}
+}
+
+func TestSharedPEMKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	// replicas loading same key verify each other sessions
+	replica, _ := newTestApp()
+	replica.SigningKeysPEM(gocqrs.ES256, "shared", shared)
+	app, _ := newTestApp()
+	app.SigningKeysPEM(gocqrs.ES256, "shared", shared)
+	token := sessionToken(t, app)
+
+	if _, err = replica.AuthToken(token); err != nil {
+		t.Fatal("replica should verify session:", err)
+	}
+	if keys := replica.Keys.JWKS()["keys"]; len(keys) != 1 || keys[0]["kid"] != "shared" {
+		t.Fatal("only shared key should be published", keys)
+	}
+
+	if _, err = gocqrs.NewPEMKeySet(gocqrs.ES256, 0, "invalid", []byte("invalid")); err == nil {
+		t.Fatal("invalid PEM should fail")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	for _, alg := range []string{gocqrs.RS256, gocqrs.ES256} {
+		app, _ := newTestApp()
+		app.SigningKeys(alg, "")
+		token := sessionToken(t, app)
+
+		var published map[string][]map[string]string
+		w := serve(app, "GET", gocqrs.JWKSPath, nil, nil)
+		err := json.Unmarshal(w.Body.Bytes(), &published)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := published["keys"]
+		if len(keys) != 1 {
+			t.Fatal(alg, "expected active key published, got", keys)
+		}
+		jwk := keys[0]
+		active, _ := app.Keys.Active()
+		if jwk["kid"] != active.ID || jwk["alg"] != alg || jwk["use"] != "sig" {
+			t.Fatal(alg, "unexpected jwk", jwk)
+		}
+		if _, private := jwk["d"]; private {
+			t.Fatal(alg, "private key published")
+		}
+
+		// published key verifies sessions
+		_, err = jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			return jwkKey(t, jwk), nil
+		})
+		if err != nil {
+			t.Fatal(alg, "jwk should verify session:", err)
+		}
+	}
+}
+
+// Public key of jwk, as verifiers decode it
+func jwkKey(t *testing.T, jwk map[string]string) interface{} {
+	n := func(field string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(jwk[field])
+		if err != nil {
+			t.Fatal("invalid jwk", field, err)
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch jwk["kty"] {
+	case "RSA":
+		return &rsa.PublicKey{N: n("n"), E: int(n("e").Int64())}
+	case "EC":
+		if jwk["crv"] != "P-256" || len(jwk["x"]) != 43 || len(jwk["y"]) != 43 {
+			t.Fatal("invalid EC jwk", jwk)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: n("x"), Y: n("y")}
+	}
+	t.Fatal("invalid jwk type", jwk)
+	return nil
+}
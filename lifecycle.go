@@ -0,0 +1,208 @@
+package gocqrs
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	// transition from any state
+	AnyState = "*"
+	// state of entities not created yet
+	NewState = ""
+
+	ActiveState  = "active"
+	DeletedState = "deleted"
+)
+
+// Event not allowed in entity current state, returned as 409 by HTTP handlers
+type TransitionError struct {
+	Entity string
+	State  string
+	Event  string
+}
+
+func (te TransitionError) Error() string {
+	state := te.State
+	if state == NewState {
+		state = "new"
+	}
+	return "Event " + te.Event + " not allowed for " + te.Entity + " in state " + state
+}
+
+type Transition struct {
+	From  string `json:"from"`
+	Event string `json:"event"`
+	To    string `json:"to"`
+}
+
+// Entity lifecycle, entity state is stored into Field. Events declared
+// in transitions are only allowed from their states, other events are
+// not restricted and keep the state.
+type StateMachine struct {
+	Field       string       `json:"field"`
+	States      []string     `json:"states"`
+	Transitions []Transition `json:"transitions"`
+	// states of soft deleted entities
+	DeletedStates []string `json:"deleted,omitempty"`
+}
+
+func NewStateMachine(field string, states ...string) *StateMachine {
+	var sm StateMachine
+	if field == "" {
+		log.Fatal("Invalid state machine field")
+	}
+	sm.Field = field
+	sm.States = states
+	sm.Transitions = make([]Transition, 0)
+	return &sm
+}
+
+// Add transition, from NewState creates entity, from AnyState applies
+// to every state.
+func (sm *StateMachine) Transition(from, event, to string) *StateMachine {
+	sm.Transitions = append(sm.Transitions, Transition{from, event, to})
+	return sm
+}
+
+// Entities in states are soft deleted
+func (sm *StateMachine) Deleted(states ...string) *StateMachine {
+	sm.DeletedStates = append(sm.DeletedStates, states...)
+	return sm
+}
+
+// CRUD lifecycle, entities are active until deleted and
+// only active entities can be updated.
+func NewCRUDStateMachine(entity string) *StateMachine {
+	ch := NewCRUDHandler(entity)
+	sm := NewStateMachine("state", ActiveState, DeletedState)
+	sm.Transition(NewState, ch.CreateEvent(), ActiveState)
+	sm.Transition(ActiveState, ch.UpdateEvent(), ActiveState)
+	sm.Transition(ActiveState, ch.DeletedEvent(), DeletedState)
+	sm.Transition(DeletedState, ch.UnDeletedEvent(), ActiveState)
+	sm.Deleted(DeletedState)
+	return sm
+}
+
+func (sm *StateMachine) Valid() error {
+	for _, t := range sm.Transitions {
+		if t.Event == "" {
+			return errors.New("Invalid transition event")
+		}
+		if t.From != NewState && t.From != AnyState && !contains(sm.States, t.From) {
+			return errors.New("Invalid transition state: " + t.From)
+		}
+		if !contains(sm.States, t.To) {
+			return errors.New("Invalid transition state: " + t.To)
+		}
+	}
+	for _, s := range sm.DeletedStates {
+		if !contains(sm.States, s) {
+			return errors.New("Invalid deleted state: " + s)
+		}
+	}
+	return nil
+}
+
+// Entity current state
+func (sm *StateMachine) State(entity *Entity) string {
+	state, _ := entity.Data[sm.Field].(string)
+	return state
+}
+
+// Next state of entity after event, ok is false if event is not
+// restricted by machine.
+func (sm *StateMachine) Next(state, event string) (next string, ok bool, err error) {
+	for _, t := range sm.Transitions {
+		if t.Event != event {
+			continue
+		}
+		ok = true
+		if t.From == state || (t.From == AnyState && state != NewState) {
+			return t.To, true, nil
+		}
+	}
+
+	if ok {
+		return "", true, errors.New("illegal transition")
+	}
+	return state, false, nil
+}
+
+// Next state of entity after event, entity is the state before
+// the event is handled. ok is false if event is not a transition.
+func (sm *StateMachine) apply(name string, event Eventer, entity *Entity) (next string, ok bool, err error) {
+	state := sm.State(entity)
+	next, ok, err = sm.Next(state, event.GetType())
+	if err != nil {
+		return "", true, TransitionError{name, state, event.GetType()}
+	}
+	return next, ok, nil
+}
+
+// Set entity state
+func (sm *StateMachine) set(entity *Entity, state string) {
+	if entity.Data == nil {
+		entity.Data = make(map[string]interface{})
+	}
+	entity.Data[sm.Field] = state
+	if len(sm.DeletedStates) > 0 {
+		entity.Deleted = contains(sm.DeletedStates, state)
+	}
+}
+
+// Restore entity state after an event that is not a transition
+func (sm *StateMachine) keep(entity *Entity, state string) {
+	if entity.Data == nil {
+		entity.Data = make(map[string]interface{})
+	}
+	if state == NewState {
+		delete(entity.Data, sm.Field)
+		return
+	}
+	entity.Data[sm.Field] = state
+}
+
+// Set entity lifecycle, invalid machines are fatal
+func (e *EntityConf) SetLifecycle(sm *StateMachine) *EntityConf {
+	err := sm.Valid()
+	if err != nil {
+		log.Fatal("Invalid lifecycle for " + e.Name + ": " + err.Error())
+	}
+	e.Lifecycle = sm
+	return e
+}
+
+// Graphviz DOT diagram of machine
+func (sm *StateMachine) Dot(name string) string {
+	quote := func(s string) string {
+		return "\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+	}
+
+	lines := []string{"digraph " + quote(name) + " {", "\trankdir=LR;", "\t\"\" [shape=point];"}
+	for _, s := range sm.States {
+		attrs := ""
+		if contains(sm.DeletedStates, s) {
+			attrs = " [style=dashed]"
+		}
+		lines = append(lines, "\t"+quote(s)+attrs+";")
+	}
+
+	edges := make([]string, 0)
+	for _, t := range sm.Transitions {
+		from := []string{t.From}
+		if t.From == AnyState {
+			from = sm.States
+		}
+		for _, f := range from {
+			edges = append(edges, "\t"+quote(f)+" -> "+quote(t.To)+" [label="+quote(t.Event)+"];")
+		}
+	}
+	sort.Strings(edges)
+
+	lines = append(lines, edges...)
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,82 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+// Task events only set task name
+type taskHandler struct{}
+
+func (h taskHandler) EventName() []string {
+	return []string{"TaskOpened", "TaskRenamed", "TaskClosed"}
+}
+
+func (h taskHandler) Handle(id, accid, userid, role string, e gocqrs.Eventer, entity *gocqrs.Entity, replay bool) (gocqrs.StoreOptions, error) {
+	if name, ok := e.GetData()["name"]; ok {
+		entity.Data["name"] = name
+	}
+	return gocqrs.StoreOptions{}, nil
+}
+
+func (h taskHandler) CheckBase(e gocqrs.Eventer) bool {
+	return false
+}
+
+func TestLifecycle(t *testing.T) {
+	app, store := newTestApp()
+	conf := gocqrs.NewEntityConf("tasks")
+	conf.AddEventHandler(taskHandler{})
+	sm := gocqrs.NewStateMachine("status", "open", "closed")
+	sm.Transition(gocqrs.NewState, "TaskOpened", "open")
+	sm.Transition("open", "TaskClosed", "closed")
+	conf.SetLifecycle(sm)
+	app.RegisterEntity(conf)
+
+	for _, step := range []struct {
+		event string
+		ok    bool
+	}{{"TaskClosed", false}, {"TaskOpened", true}, {"TaskRenamed", true}, {"TaskClosed", true}, {"TaskClosed", false}} {
+		ev := gocqrs.NewEvent("", step.event, map[string]interface{}{"name": step.event})
+		ev.Entity = "tasks"
+		ev.EntityID = "t1"
+		_, _, err := app.HandleEvent("tasks", "t1", "acc", "tester", "", ev, 0)
+		if (err == nil) != step.ok {
+			t.Fatal("unexpected result of", step.event, err)
+		}
+	}
+
+	// state can't be set by other events
+	ev := gocqrs.NewEvent("", "TaskRenamed", map[string]interface{}{"name": "reopened", "status": "open"})
+	ev.Entity = "tasks"
+	ev.EntityID = "t1"
+	_, _, err := app.HandleEvent("tasks", "t1", "acc", "tester", "", ev, 0)
+	if _, ok := err.(gocqrs.ValidationErrors); !ok {
+		t.Fatal("expected validation errors setting state, got", err)
+	}
+
+	// only transitions carry state
+	ch, _ := store.Range(gocqrs.EntityStream("acc", "", "tasks", "t1"))
+	for e := range ch {
+		_, stamped := e.GetData()["status"]
+		if stamped == (e.GetType() == "TaskRenamed") {
+			t.Fatal("unexpected state data on", e.GetType(), e.GetData())
+		}
+	}
+
+	p := gocqrs.NewMemProjection(conf, "")
+	p.Projector.UseAggregate = true
+	for e := range store.Scan(p.Stream(), 1, 3) {
+		err := p.Apply(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entity, err := p.Get("acc", "", "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity.Data["status"] != "closed" || entity.Version != 3 {
+		t.Fatal("unexpected projected task", entity.Version, entity.Data)
+	}
+}
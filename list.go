@@ -0,0 +1,236 @@
+package gocqrs
+
+import (
+	"context"
+	"errors"
+	"gopkg.in/gin-gonic/gin.v1"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Listing query params, any other param is a field filter:
+// status=active, age[gte]=18. Values are parsed as numbers, booleans
+// or null when possible, quote them to filter by string: zip="0800"
+const (
+	SortParam    = "sort"
+	LimitParam   = "limit"
+	CursorParam  = "cursor"
+	DeletedParam = "deleted"
+	// wait for projection view to apply version of its stream
+	MinVersionParam = "minVersion"
+)
+
+var (
+	NotListableError = errors.New("Entity has no projection to list")
+	HiddenFieldError = errors.New("Can't filter or sort by hidden field")
+)
+
+// Set projection used to list entity, if projection is also a
+// Viewer it's added to app views.
+func (app *App) AddProjection(entity string, p Projection) {
+	econf, ok := app.Entities[entity]
+	if !ok {
+		log.Fatal("Invalid entity to add projection: " + entity)
+	}
+	if econf.Projection != nil {
+		log.Fatal("Entity already has projection: " + entity)
+	}
+	econf.Projection = p
+
+	if v, ok := p.(Viewer); ok {
+		app.AddView(NewView(ProjectionView(entity), v), map[string]string{}, false)
+	}
+}
+
+// Name of view building entity projection
+func ProjectionView(entity string) string {
+	return entity + "_projection"
+}
+
+// Parse listing query from url params, sort=-field sorts descending
+func ParseQuery(values url.Values) (Query, error) {
+	var q Query
+	var err error
+	q.Filters = make([]Filter, 0)
+
+	for k, vs := range values {
+		if len(vs) == 0 {
+			continue
+		}
+		v := vs[0]
+
+		switch k {
+		case FieldsParam:
+		case SortParam:
+			q.Sort = strings.TrimPrefix(v, "-")
+			q.Desc = strings.HasPrefix(v, "-")
+		case LimitParam:
+			q.Limit, err = strconv.Atoi(v)
+			if err != nil || q.Limit < 0 {
+				return q, errors.New("Invalid limit")
+			}
+		case MinVersionParam:
+		case CursorParam:
+			q.Cursor = v
+		case DeletedParam:
+			q.Deleted = v == "true"
+		default:
+			f := Filter{Field: k, Op: EqOp}
+			if i := strings.Index(k, "["); i > 0 && strings.HasSuffix(k, "]") {
+				f.Field = k[:i]
+				f.Op = k[i+1 : len(k)-1]
+			}
+
+			switch f.Op {
+			case EqOp, NeOp, GtOp, GteOp, LtOp, LteOp:
+			default:
+				return q, InvalidOpError
+			}
+
+			f.Value = parseValue(v)
+			q.Filters = append(q.Filters, f)
+		}
+	}
+	return q, nil
+}
+
+func parseValue(v string) interface{} {
+	if len(v) > 1 && strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
+		return v[1 : len(v)-1]
+	}
+
+	switch v {
+	case "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+	if err == nil {
+		return n
+	}
+	return v
+}
+
+// List entities from entity projection
+func EntitiesHandler(c *gin.Context) {
+	var claims *SessionClaims
+	var accid string
+	var err error
+
+	e := c.Param("entity")
+	econf, ok := runningApp.Entities[e]
+	if !ok {
+		c.JSON(400, map[string]string{"error": "invalid entity conf"})
+		return
+	}
+
+	if econf.Projection == nil {
+		c.JSON(404, map[string]string{"error": NotListableError.Error()})
+		return
+	}
+
+	if !runningApp.AuthOff {
+		claims, err = runningApp.authRead(e, c)
+		if err != nil {
+			c.JSON(401, map[string]string{"error": err.Error()})
+			return
+		}
+
+		accid, err = runningApp.account(claims, c)
+		if err != nil {
+			c.JSON(403, map[string]string{"error": err.Error()})
+			return
+		}
+	} else {
+		accid, err = requestAccount(c)
+		if err != nil {
+			c.JSON(400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	// group entities are only readable by members
+	group := c.Request.Header.Get(EntityGroupHeader)
+	if group != "" && !runningApp.AuthOff {
+		err = runningApp.IsMember(accid, group, claims.Username)
+		if err != nil {
+			c.JSON(403, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	q, err := ParseQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+	q.AccountID = runningApp.tenant(econf, accid)
+	q.Group = group
+
+	// matching on fields caller can't read would leak their values
+	for _, f := range q.Filters {
+		if !econf.Visible(f.Field, claims) {
+			c.JSON(400, map[string]string{"error": HiddenFieldError.Error() + ": " + f.Field})
+			return
+		}
+	}
+	if q.Sort != "" && !econf.Visible(q.Sort, claims) {
+		c.JSON(400, map[string]string{"error": HiddenFieldError.Error() + ": " + q.Sort})
+		return
+	}
+
+	// entities denied by policies are not listed nor counted
+	if !runningApp.AuthOff {
+		q.Allow = func(entity *Entity) bool {
+			return econf.checkPolicies(claims, nil, entity) == nil
+		}
+	}
+
+	// read your writes, wait for projection to reach command version
+	if mv := c.Query(MinVersionParam); mv != "" {
+		version, err := strconv.ParseUint(mv, 10, 64)
+		if err != nil {
+			c.JSON(400, map[string]string{"error": "Invalid minVersion"})
+			return
+		}
+
+		v, ok := econf.Projection.(Viewer)
+		if !ok {
+			c.JSON(400, map[string]string{"error": "Projection is not a view"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), runningApp.SyncTimeout)
+		err = runningApp.Views.WaitFor(ctx, ProjectionView(e), v.Stream(), version)
+		cancel()
+		if err == WaitTimeoutError {
+			c.JSON(504, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(400, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	res, err := econf.Projection.Query(q)
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+
+	fields := ParseFields(c.Query(FieldsParam))
+	entities := make([]*Entity, 0)
+	for _, entity := range res.Entities {
+		entities = append(entities, econf.Redact(entity, claims, fields))
+	}
+	res.Entities = entities
+
+	c.JSON(200, res)
+}
@@ -0,0 +1,116 @@
+package gocqrs
+
+import (
+	"errors"
+	"log"
+)
+
+var (
+	NotOwnerError       = errors.New("Only creator can change entity")
+	InvalidAccountError = errors.New("Entity belongs to other account")
+)
+
+// Policy checks if session can execute event over entity, or read it.
+// On reads event is nil. Entity is the current state, before the event is handled.
+type Policy interface {
+	GetName() string
+	Allow(claims *SessionClaims, event Eventer, entity *Entity) error
+}
+
+type PolicyError struct {
+	Policy string
+	Err    error
+}
+
+func (pe PolicyError) Error() string {
+	return "Not allowed by policy: " + pe.Policy + " - " + pe.Err.Error()
+}
+
+func (e *EntityConf) AddPolicy(p ...Policy) error {
+	var err error
+	if e.Policies == nil {
+		e.Policies = make(map[string]Policy)
+	}
+
+	for _, policy := range p {
+		_, exist := e.Policies[policy.GetName()]
+		if exist {
+			log.Fatal("Could not add policy, already set:" + policy.GetName())
+		} else {
+			e.Policies[policy.GetName()] = policy
+		}
+	}
+	return err
+}
+
+func (e *EntityConf) checkPolicies(claims *SessionClaims, event Eventer, entity *Entity) error {
+	for n, p := range e.Policies {
+		err := p.Allow(claims, event, entity)
+		if err != nil {
+			return PolicyError{n, err}
+		}
+	}
+	return nil
+}
+
+// Only creator of entity (createdBy) or roles can execute events,
+// reads are not restricted.
+type OwnerPolicy struct {
+	Roles []string `json:"roles"`
+}
+
+func (op OwnerPolicy) GetName() string {
+	return "owner-policy"
+}
+
+func (op OwnerPolicy) Allow(claims *SessionClaims, event Eventer, entity *Entity) error {
+	if event == nil {
+		return nil
+	}
+
+	for _, r := range op.Roles {
+		if r == claims.Role {
+			return nil
+		}
+	}
+
+	createdBy, has := entity.Data["createdBy"]
+	if !has {
+		// new entity
+		return nil
+	}
+
+	if createdBy != claims.Username {
+		return NotOwnerError
+	}
+	return nil
+}
+
+// Entity account (accID) must match session account, for reads and events.
+// Roles are allowed to access any account.
+type AccountPolicy struct {
+	Roles []string `json:"roles"`
+}
+
+func (ap AccountPolicy) GetName() string {
+	return "account-policy"
+}
+
+func (ap AccountPolicy) Allow(claims *SessionClaims, event Eventer, entity *Entity) error {
+	for _, r := range ap.Roles {
+		if r == claims.Role {
+			return nil
+		}
+	}
+
+	accid, has := entity.Data["accID"]
+	if !has {
+		// new entity
+		return nil
+	}
+
+	if accid != claims.AccountID {
+		return InvalidAccountError
+	}
+	return nil
+}
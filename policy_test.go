@@ -0,0 +1,71 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+type post struct {
+	Title string `json:"title"`
+}
+
+// App with auth on, global posts owned by creator and account
+func newPolicyApp(t *testing.T) (*gocqrs.App, func(user, accid string) map[string]string) {
+	app, _ := newTestApp()
+	app.AuthOff = false
+	app.Secret = "secret"
+	app.Auth()
+	app.AddRoles(*gocqrs.NewRole("member"))
+
+	posts := gocqrs.NewEntityConf("posts")
+	posts.Global = true
+	posts.AddCRUD(false)
+	posts.SetBaseStruct(post{})
+	posts.AddPolicy(gocqrs.OwnerPolicy{}, gocqrs.AccountPolicy{})
+	app.RegisterEntity(posts)
+	app.Routes()
+
+	session := func(user, accid string) map[string]string {
+		token, err := gocqrs.BuildToken(gocqrs.User{Username: user, Role: "member", AccountID: accid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return map[string]string{gocqrs.SessionHeader: token}
+	}
+	return app, session
+}
+
+func TestPolicyDenied(t *testing.T) {
+	app, session := newPolicyApp(t)
+
+	send := func(header map[string]string, eventType string) int {
+		header[gocqrs.EventTypeHeader] = eventType
+		header[gocqrs.EntityHeader] = "p1"
+		return serveJSON(app, "POST", "/event/posts", `{"title":"hello"}`, header).Code
+	}
+	read := func(header map[string]string) int {
+		return serve(app, "GET", "/entity/posts/p1", nil, header).Code
+	}
+
+	if code := send(session("ann", "acc1"), "PostsCreated"); code != 201 {
+		t.Fatal("expected 201 creating post, got", code)
+	}
+
+	for _, c := range []struct {
+		name   string
+		header map[string]string
+		write  int
+		read   int
+	}{
+		{"owner", session("ann", "acc1"), 201, 200},
+		{"not owner", session("bob", "acc1"), 403, 200},
+		{"other account", session("ann", "acc2"), 403, 403},
+	} {
+		if code := send(c.header, "PostsUpdated"); code != c.write {
+			t.Fatal(c.name, "expected", c.write, "on write, got", code)
+		}
+		if code := read(c.header); code != c.read {
+			t.Fatal(c.name, "expected", c.read, "on read, got", code)
+		}
+	}
+}
@@ -0,0 +1,540 @@
+package gocqrs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Query operators
+const (
+	EqOp  = "eq"
+	NeOp  = "ne"
+	GtOp  = "gt"
+	GteOp = "gte"
+	LtOp  = "lt"
+	LteOp = "lte"
+)
+
+const (
+	DefaultQueryLimit = 50
+)
+
+var (
+	InvalidOpError     = errors.New("Invalid query operator")
+	InvalidCursorError = errors.New("Invalid query cursor")
+	EntityNotFound     = errors.New("Entity not found")
+)
+
+// Queryable read model of entities
+type Projection interface {
+	Get(accid, group, id string) (*Entity, error)
+	Query(q Query) (*QueryResult, error)
+}
+
+type Filter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+type Query struct {
+	// entities of account and group
+	AccountID string `json:"accID"`
+	Group     string `json:"group"`
+
+	Filters []Filter `json:"filters"`
+	// sort field, default entity id
+	Sort string `json:"sort"`
+	Desc bool   `json:"desc"`
+
+	Limit  int    `json:"limit"`
+	Cursor string `json:"cursor"`
+	// include soft deleted entities
+	Deleted bool `json:"deleted"`
+	// entities allowed to caller, nil allows all
+	Allow func(*Entity) bool `json:"-"`
+}
+
+type QueryResult struct {
+	Entities []*Entity `json:"entities"`
+	Total    int       `json:"total"`
+	// cursor of next page, empty if last page
+	Next string `json:"next,omitempty"`
+}
+
+// Entity state stored into projection
+type Record struct {
+	Key       string  `json:"key"`
+	AccountID string  `json:"accID"`
+	Group     string  `json:"group"`
+	Entity    *Entity `json:"entity"`
+}
+
+// Value of entity field, id and version are also fields
+func (r *Record) Field(f string) interface{} {
+	switch f {
+	case "", "id":
+		return r.Entity.ID
+	case "version":
+		return float64(r.Entity.Version)
+	}
+	return r.Entity.Data[f]
+}
+
+// Builds entity states from entity events, using CRUD events or
+// running entity event handlers (Aggregate).
+type Projector struct {
+	Conf         *EntityConf
+	UseAggregate bool
+}
+
+// Key of entity in projection, unique across accounts and groups
+func ProjectionKey(accid, group, entity, id string) string {
+	return EntityStream(accid, group, entity, id)
+}
+
+// Apply event to current entity state, current is nil for new entities.
+// Returns nil record if event is not handled.
+func (p Projector) Project(e Event, current *Record) (*Record, error) {
+	// stores only know entity stream category
+	if e.Entity != p.Conf.Name && e.Entity != p.Conf.Category() {
+		return nil, nil
+	}
+
+	var r *Record
+	if current != nil {
+		// current state is kept if handler fails
+		c := *current
+		c.Entity = copyEntity(current.Entity)
+		r = &c
+	} else {
+		r = &Record{
+			Key:       ProjectionKey(e.AccountID, e.Group, p.Conf.Name, e.EntityID),
+			AccountID: e.AccountID,
+			Group:     e.Group,
+			Entity: &Entity{
+				ID:    e.EntityID,
+				Group: e.Group,
+				Data:  make(map[string]interface{}),
+			},
+		}
+	}
+	entity := r.Entity
+
+	// stored events were legal, state is only moved
+	state, transition := "", false
+	if p.Conf.Lifecycle != nil {
+		state, transition, _ = p.Conf.Lifecycle.apply(p.Conf.Name, &e, entity)
+	}
+
+	if p.UseAggregate {
+		h, has := p.Conf.EventHandlers[e.GetType()]
+		if !has {
+			return nil, nil
+		}
+		_, err := h.Handle(e.EntityID, "", "", "", &e, entity, true)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ch := NewCRUDHandler(p.Conf.Name)
+		switch e.GetType() {
+		case ch.CreateEvent():
+			entity.Data = make(map[string]interface{})
+			for k, v := range e.GetData() {
+				entity.Data[k] = v
+			}
+		case ch.UpdateEvent():
+			for k, v := range e.GetData() {
+				entity.Data[k] = v
+			}
+		case ch.DeletedEvent():
+			entity.Deleted = true
+		case ch.UnDeletedEvent(), ch.legacyUnDeletedEvent():
+			entity.Deleted = false
+		default:
+			return nil, nil
+		}
+	}
+
+	if transition && state != NewState {
+		p.Conf.Lifecycle.set(entity, state)
+	} else if p.Conf.Lifecycle != nil && !transition {
+		p.Conf.Lifecycle.keep(entity, state)
+	}
+
+	entity.ID = e.EntityID
+	// replayed events keep entity version
+	entity.Version = e.EntityVersion
+	if entity.Version == 0 {
+		entity.Version = e.EventVersion
+	}
+	return r, nil
+}
+
+// Check record matches query filters
+func (q Query) Match(r *Record) (bool, error) {
+	if r.AccountID != q.AccountID || r.Group != q.Group {
+		return false, nil
+	}
+	if r.Entity.Deleted && !q.Deleted {
+		return false, nil
+	}
+
+	for _, f := range q.Filters {
+		v := r.Field(f.Field)
+		c := CompareValues(v, f.Value)
+		// range filters only match values of same type
+		if f.Op != EqOp && f.Op != NeOp && f.Op != "" && ValueRank(v) != ValueRank(f.Value) {
+			return false, nil
+		}
+		var ok bool
+		switch f.Op {
+		case EqOp, "":
+			ok = c == 0
+		case NeOp:
+			ok = c != 0
+		case GtOp:
+			ok = c > 0
+		case GteOp:
+			ok = c >= 0
+		case LtOp:
+			ok = c < 0
+		case LteOp:
+			ok = c <= 0
+		default:
+			return false, InvalidOpError
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	if q.Allow != nil && !q.Allow(r.Entity) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Position of last entity of page, sort value and key
+type cursor struct {
+	Value interface{} `json:"v"`
+	Key   string      `json:"k"`
+}
+
+func (q Query) less(a, b cursor) bool {
+	c := CompareValues(a.Value, b.Value)
+	if c == 0 {
+		c = CompareValues(a.Key, b.Key)
+	}
+	if q.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+// Filter, sort and paginate candidate records
+func (q Query) Run(candidates []*Record) (*QueryResult, error) {
+	var res QueryResult
+	res.Entities = make([]*Entity, 0)
+
+	matched := make([]*Record, 0)
+	for _, r := range candidates {
+		ok, err := q.Match(r)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+	res.Total = len(matched)
+
+	sort.Slice(matched, func(i, j int) bool {
+		return q.less(cursor{matched[i].Field(q.Sort), matched[i].Key}, cursor{matched[j].Field(q.Sort), matched[j].Key})
+	})
+
+	start := 0
+	if q.Cursor != "" {
+		var last cursor
+		b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return nil, InvalidCursorError
+		}
+		err = json.Unmarshal(b, &last)
+		if err != nil {
+			return nil, InvalidCursorError
+		}
+
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.less(last, cursor{matched[i].Field(q.Sort), matched[i].Key})
+		})
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	end := start + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	for _, r := range matched[start:end] {
+		res.Entities = append(res.Entities, r.Entity)
+	}
+
+	if end < len(matched) {
+		last := matched[end-1]
+		b, _ := json.Marshal(cursor{last.Field(q.Sort), last.Key})
+		res.Next = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	return &res, nil
+}
+
+// Compare JSON values, numbers < strings < bools, nil is lowest
+func CompareValues(a, b interface{}) int {
+	ra, rb := ValueRank(a), ValueRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch ra {
+	case 1:
+		fa, fb := ToFloat(a), ToFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+	case 2:
+		sa, sb := a.(string), b.(string)
+		switch {
+		case sa < sb:
+			return -1
+		case sa > sb:
+			return 1
+		}
+	case 3:
+		ba, bb := a.(bool), b.(bool)
+		if ba != bb {
+			if !ba {
+				return -1
+			}
+			return 1
+		}
+	case 4:
+		ja, _ := json.Marshal(a)
+		jb, _ := json.Marshal(b)
+		return CompareValues(string(ja), string(jb))
+	}
+	return 0
+}
+
+// Type order of values: nil, number, string, bool, others
+func ValueRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case float64, float32, int, int64, int32, uint64, uint32, uint:
+		return 1
+	case string:
+		return 2
+	case bool:
+		return 3
+	}
+	return 4
+}
+
+func ToFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	case uint32:
+		return float64(n)
+	case uint:
+		return float64(n)
+	}
+	return 0
+}
+
+// In memory projection of every entity of an entity conf,
+// equality filters on indexed fields use the index.
+type MemProjection struct {
+	lock      sync.RWMutex
+	Projector Projector
+	stream    string
+
+	records map[string]*Record
+	// field -> value -> keys
+	indexes map[string]map[string]map[string]bool
+}
+
+// Projection of entity conf built from stream, entity category
+// index stream if empty.
+func NewMemProjection(conf *EntityConf, stream string) *MemProjection {
+	var m MemProjection
+	if stream == "" {
+		stream = CategoryStream(conf.Category())
+	}
+	m.Projector = Projector{Conf: conf}
+	m.stream = stream
+	m.records = make(map[string]*Record)
+	m.indexes = make(map[string]map[string]map[string]bool)
+	return &m
+}
+
+// Add secondary indexes on entity fields
+func (m *MemProjection) Index(fields ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, f := range fields {
+		if _, ok := m.indexes[f]; ok {
+			continue
+		}
+		m.indexes[f] = make(map[string]map[string]bool)
+		for key, r := range m.records {
+			m.indexAdd(f, r.Field(f), key)
+		}
+	}
+}
+
+func indexValue(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (m *MemProjection) indexAdd(field string, v interface{}, key string) {
+	iv := indexValue(v)
+	if m.indexes[field][iv] == nil {
+		m.indexes[field][iv] = make(map[string]bool)
+	}
+	m.indexes[field][iv][key] = true
+}
+
+func (m *MemProjection) indexRemove(field string, v interface{}, key string) {
+	delete(m.indexes[field][indexValue(v)], key)
+}
+
+func (m *MemProjection) Init(params map[string]string, dev bool) {}
+
+func (m *MemProjection) Purge() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.records = make(map[string]*Record)
+	for f, _ := range m.indexes {
+		m.indexes[f] = make(map[string]map[string]bool)
+	}
+	return nil
+}
+
+// Position is owned by view runner
+func (m *MemProjection) Status() (uint64, error) {
+	return 0, nil
+}
+
+func (m *MemProjection) Stream() string {
+	return m.stream
+}
+
+func (m *MemProjection) Rebuild() error {
+	return m.Purge()
+}
+
+func (m *MemProjection) Apply(e Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := ProjectionKey(e.AccountID, e.Group, m.Projector.Conf.Name, e.EntityID)
+	current := m.records[key]
+
+	r, err := m.Projector.Project(e, current)
+	if err != nil || r == nil {
+		return err
+	}
+
+	for f, _ := range m.indexes {
+		if current != nil {
+			m.indexRemove(f, current.Field(f), key)
+		}
+		m.indexAdd(f, r.Field(f), key)
+	}
+	m.records[key] = r
+	return nil
+}
+
+func (m *MemProjection) Get(accid, group, id string) (*Entity, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	r, ok := m.records[ProjectionKey(accid, group, m.Projector.Conf.Name, id)]
+	if !ok {
+		return nil, EntityNotFound
+	}
+	return copyEntity(r.Entity), nil
+}
+
+func (m *MemProjection) Query(q Query) (*QueryResult, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var candidates []*Record
+	for _, f := range q.Filters {
+		index, indexed := m.indexes[f.Field]
+		if indexed && (f.Op == EqOp || f.Op == "") {
+			candidates = make([]*Record, 0)
+			for key, _ := range index[indexValue(f.Value)] {
+				candidates = append(candidates, m.records[key])
+			}
+			break
+		}
+	}
+
+	if candidates == nil {
+		candidates = make([]*Record, 0, len(m.records))
+		for _, r := range m.records {
+			candidates = append(candidates, r)
+		}
+	}
+
+	res, err := q.Run(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// results should not change with new events
+	for i, e := range res.Entities {
+		res.Entities[i] = copyEntity(e)
+	}
+	return res, nil
+}
+
+func copyEntity(e *Entity) *Entity {
+	c := *e
+	c.Data = make(map[string]interface{})
+	for k, v := range e.Data {
+		c.Data[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,48 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"strconv"
+	"testing"
+)
+
+func TestProjectionQueryAllow(t *testing.T) {
+	app, store := newTestApp()
+	for i := 1; i <= 6; i++ {
+		id := "i" + strconv.Itoa(i)
+		_, err := handle(app, "acc", "ItemsCreated", id, map[string]interface{}{"name": id, "price": float64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := gocqrs.NewMemProjection(app.Entities["items"], "")
+	for e := range store.Scan(p.Stream(), 1, 6) {
+		err := p.Apply(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// denied entities are skipped before paging
+	q := gocqrs.Query{AccountID: "acc", Sort: "price", Limit: 2}
+	q.Allow = func(e *gocqrs.Entity) bool {
+		return e.Data["price"].(float64) > 2
+	}
+	res, err := p.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total != 4 || len(res.Entities) != 2 || res.Entities[0].ID != "i3" {
+		t.Fatal("unexpected allowed page", res.Total, res.Entities)
+	}
+
+	q.Cursor = res.Next
+	res, err = p.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entities) != 2 || res.Entities[1].ID != "i6" || res.Next != "" {
+		t.Fatal("unexpected last page", res.Entities, res.Next)
+	}
+}
@@ -1,5 +1,88 @@
 package gocqrs
 
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// X-Consistency value waiting for readers
+	StrongConsistency  = "strong"
+	DefaultSyncTimeout = time.Duration(time.Second * 5)
+)
+
+var (
+	SyncTimeoutError = errors.New("Timeout waiting for readers sync")
+)
+
+// Read model synced after every handled event of an entity.
+// Sync should read the entity current state, syncs of the same
+// entity may run concurrently. Ids are only unique into account
+// and group, readers of account entities should be ScopedReaders.
 type Reader interface {
 	Sync(entity, id string) error
 }
+
+// Reader synced with scope of handled event, entity is read with
+// Scope.Entity. SyncScope is called instead of Sync.
+type ScopedReader interface {
+	Reader
+	SyncScope(s Scope, entity, id string) error
+}
+
+func (app *App) AddReader(r Reader) {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+	app.Readers = append(app.Readers, r)
+}
+
+// Sync entity into every reader in background, returned channel gets
+// first reader error or nil once all readers finished.
+func (app *App) syncReaders(s Scope, entity, id string) <-chan error {
+	done := make(chan error, 1)
+	readers := append([]Reader{}, app.Readers...)
+
+	go func() {
+		var wg sync.WaitGroup
+		errs := make(chan error, len(readers))
+		for _, r := range readers {
+			wg.Add(1)
+			go func(r Reader) {
+				defer wg.Done()
+				var err error
+				if sr, ok := r.(ScopedReader); ok {
+					err = sr.SyncScope(s, entity, id)
+				} else {
+					err = r.Sync(entity, id)
+				}
+				if err != nil {
+					log.Println("Failed to sync", entity, id, ":", err)
+					errs <- err
+				}
+			}(r)
+		}
+		wg.Wait()
+		close(errs)
+
+		done <- <-errs
+		close(done)
+	}()
+
+	return done
+}
+
+// Wait readers sync until app sync timeout
+func (app *App) waitSync(synced <-chan error) error {
+	if synced == nil {
+		return nil
+	}
+
+	select {
+	case err := <-synced:
+		return err
+	case <-time.After(app.SyncTimeout):
+		return SyncTimeoutError
+	}
+}
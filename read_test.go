@@ -0,0 +1,45 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"testing"
+	"time"
+)
+
+// Reader loading synced entities
+type loader struct {
+	synced chan *gocqrs.Entity
+}
+
+func (l loader) Sync(entity, id string) error {
+	return nil
+}
+
+func (l loader) SyncScope(s gocqrs.Scope, entity, id string) error {
+	e, _, err := s.Entity(entity, id)
+	if err != nil {
+		return err
+	}
+	l.synced <- e
+	return nil
+}
+
+func TestScopedReader(t *testing.T) {
+	app, _ := newTestApp()
+	l := loader{make(chan *gocqrs.Entity, 1)}
+	app.AddReader(l)
+
+	_, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-l.synced:
+		if e.Version != 1 || e.Data["name"] != "pen" {
+			t.Fatal("reader should load account entity, got", e.Version, e.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reader not synced")
+	}
+}
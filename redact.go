@@ -0,0 +1,81 @@
+package gocqrs
+
+import (
+	"strings"
+)
+
+const (
+	FieldsParam = "fields"
+)
+
+// Fields never returned on reads
+func (e *EntityConf) Hide(fields ...string) *EntityConf {
+	for _, f := range fields {
+		e.HiddenFields = append(e.HiddenFields, f)
+	}
+	return e
+}
+
+// Field only returned on reads to roles
+func (e *EntityConf) ShowTo(field string, roles ...string) *EntityConf {
+	if e.RoleFields == nil {
+		e.RoleFields = make(map[string][]string)
+	}
+	e.RoleFields[field] = append(e.RoleFields[field], roles...)
+	return e
+}
+
+// Returns copy of entity with hidden fields removed, fields only visible to
+// other roles removed and, if fields is not empty, only those fields.
+// Nil claims (auth off) have no role, role restricted fields are removed.
+func (e *EntityConf) Redact(entity *Entity, claims *SessionClaims, fields []string) *Entity {
+	redacted := *entity
+	redacted.Data = make(map[string]interface{})
+
+	for k, v := range entity.Data {
+		if len(fields) > 0 && !contains(fields, k) {
+			continue
+		}
+
+		if !e.Visible(k, claims) {
+			continue
+		}
+
+		redacted.Data[k] = v
+	}
+
+	return &redacted
+}
+
+// Check field is returned on reads to claims
+func (e *EntityConf) Visible(field string, claims *SessionClaims) bool {
+	if contains(e.HiddenFields, field) {
+		return false
+	}
+
+	if roles, restricted := e.RoleFields[field]; restricted {
+		return claims != nil && contains(roles, claims.Role)
+	}
+	return true
+}
+
+// Parse fields projection, comma separated
+func ParseFields(s string) []string {
+	fields := make([]string, 0)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package gocqrs_test
+
+import (
+	"encoding/json"
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+func TestUserSecretsRedacted(t *testing.T) {
+	app, session := newPolicyApp(t)
+	ev := gocqrs.NewEvent("", gocqrs.UserCreatedEvent, map[string]interface{}{"password": "secret", "role": "member"})
+	ev.Entity = gocqrs.UserEntity
+	ev.EntityID = "ann"
+	_, _, err := app.HandleEvent(gocqrs.UserEntity, "ann", "acc1", "admin", "", ev, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := app.Entities[gocqrs.UserEntity]
+	for _, f := range []string{"password", "token"} {
+		if users.Visible(f, &gocqrs.SessionClaims{Username: "ann", Role: "member"}) || users.Visible(f, nil) {
+			t.Fatal(f, "should never be visible")
+		}
+	}
+
+	for _, path := range []string{"/entity/users/ann", "/entity/users/ann?fields=username,password,token"} {
+		w := serve(app, "GET", path, nil, session("ann", "acc1"))
+		if w.Code != 200 {
+			t.Fatal("expected 200 reading user, got", w.Code, w.Body.String())
+		}
+
+		var u gocqrs.Entity
+		err = json.Unmarshal(w.Body.Bytes(), &u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Data["username"] != "ann" {
+			t.Fatal("expected username, got", w.Body.String())
+		}
+		if _, has := u.Data["password"]; has {
+			t.Fatal("password returned", w.Body.String())
+		}
+		if _, has := u.Data["token"]; has {
+			t.Fatal("token returned", w.Body.String())
+		}
+	}
+}
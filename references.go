@@ -0,0 +1,553 @@
+package gocqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/gin-gonic/gin.v1"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// What happens to referencing entities when referenced entity is deleted
+const (
+	// nothing, reference is kept
+	NoAction = ""
+	// delete is rejected while entity is referenced
+	RestrictDelete = "restrict"
+	// referencing entities are deleted
+	CascadeDelete = "cascade"
+	// reference is set to null, reference should be nullable
+	NullifyDelete = "nullify"
+)
+
+// Reverse references are tracked into one stream per referenced entity
+const (
+	ReferencesEntity = "$refs"
+
+	ReferenceAddedEvent   = "ReferenceAdded"
+	ReferenceRemovedEvent = "ReferenceRemoved"
+
+	ReferenceSourceKey = "source"
+	ReferenceKey       = "key"
+
+	// reference key segment suffix, every item of array is referenced
+	ArrayWildcard = "[]"
+)
+
+var (
+	InvalidReferenceTypeError = errors.New("Invalid reference type, should be string")
+	DeletedReferenceError     = errors.New("Referenced entity is deleted")
+)
+
+// Delete rejected by restrict reference, returned as 409 by HTTP handlers
+type ReferencedError struct {
+	Entity string
+	ID     string
+	// referencing entity stream and key
+	Source string
+	Key    string
+}
+
+func (re ReferencedError) Error() string {
+	return "Entity " + re.Entity + " " + re.ID + " is referenced by " + re.Source + " (" + re.Key + ")"
+}
+
+// Reference with delete action, nullify requires nullable reference.
+// Key is a dotted path into entity data, "[]" suffix on a segment
+// references every item of array: "shipping.addressId", "items[].productId"
+func (e *EntityConf) ReferenceOnDelete(en, k string, null bool, action string) {
+	if !validReferenceKey(k) {
+		log.Fatal("Invalid reference key: " + e.Name + "." + k)
+	}
+	switch action {
+	case NoAction, RestrictDelete, CascadeDelete:
+	case NullifyDelete:
+		if !null {
+			log.Fatal("Nullify reference should be nullable: " + e.Name + "." + k)
+		}
+	default:
+		log.Fatal("Invalid reference delete action: " + action)
+	}
+	e.EntityReferences = append(e.EntityReferences, EntityReference{en, k, null, action})
+}
+
+func validReferenceKey(k string) bool {
+	for _, segment := range strings.Split(k, ".") {
+		field := strings.TrimSuffix(segment, ArrayWildcard)
+		if field == "" || strings.ContainsAny(field, "[]") {
+			return false
+		}
+	}
+	return true
+}
+
+// Referenced id found at path of entity data
+type referenceValue struct {
+	Path string
+	ID   string
+}
+
+func referenceIDs(values []referenceValue) []string {
+	ids := make([]string, 0)
+	for _, v := range values {
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+// Referenced ids into entity state with their paths, values of
+// JSON arrays are indexed: "items[0].productId". Missing fields are null.
+func (r EntityReference) values(entity *Entity) ([]referenceValue, ValidationErrors) {
+	values := make([]referenceValue, 0)
+	invalid := make(ValidationErrors, 0)
+
+	fail := func(path, msg string, v interface{}) {
+		b, _ := json.Marshal(v)
+		invalid = append(invalid, ValidationError{Field: path, Rule: "reference", Message: msg + ": " + string(b)})
+	}
+
+	var walk func(path string, v interface{}, segments []string)
+	walk = func(path string, v interface{}, segments []string) {
+		if len(segments) == 0 {
+			switch value := v.(type) {
+			case string:
+				values = append(values, referenceValue{path, value})
+				return
+			case []string, []interface{}:
+				// list of references
+				for i, item := range arrayItems(value) {
+					walk(indexPath(path, i), item, segments)
+				}
+				return
+			case nil:
+				if r.Null {
+					return
+				}
+				fail(path, "reference should not be null", v)
+				return
+			}
+			fail(path, InvalidReferenceTypeError.Error(), v)
+			return
+		}
+
+		field := strings.TrimSuffix(segments[0], ArrayWildcard)
+		var child interface{}
+		switch data := v.(type) {
+		case map[string]interface{}:
+			child = data[field]
+		case nil:
+		default:
+			fail(path, "should be an object", v)
+			return
+		}
+		path = fieldPath(path, field)
+
+		if field == segments[0] {
+			walk(path, child, segments[1:])
+			return
+		}
+		switch child.(type) {
+		case []string, []interface{}:
+			for i, item := range arrayItems(child) {
+				walk(indexPath(path, i), item, segments[1:])
+			}
+		case nil:
+			walk(path, nil, nil)
+		default:
+			fail(path, "should be an array", child)
+		}
+	}
+
+	walk("", entity.Data, strings.Split(r.Key, "."))
+	return values, invalid
+}
+
+// Top level field of entity data and its value with references to id
+// set to null, or removed from lists of references. Data is not modified.
+func (r EntityReference) nullify(data map[string]interface{}, id string) (string, interface{}) {
+	segments := strings.Split(r.Key, ".")
+	top := strings.TrimSuffix(segments[0], ArrayWildcard)
+
+	var remove func(v interface{}, segments []string) interface{}
+	remove = func(v interface{}, segments []string) interface{} {
+		if len(segments) == 0 {
+			switch value := v.(type) {
+			case string:
+				if value == id {
+					return nil
+				}
+			case []string:
+				kept := make([]string, 0)
+				for _, item := range value {
+					if item != id {
+						kept = append(kept, item)
+					}
+				}
+				return kept
+			case []interface{}:
+				kept := make([]interface{}, 0)
+				for _, item := range value {
+					if item != id {
+						kept = append(kept, remove(item, segments))
+					}
+				}
+				return kept
+			}
+			return v
+		}
+
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		field := strings.TrimSuffix(segments[0], ArrayWildcard)
+		c := make(map[string]interface{})
+		for k, item := range obj {
+			c[k] = item
+		}
+
+		items, ok := c[field].([]interface{})
+		switch {
+		case field == segments[0] || len(segments) == 1:
+			c[field] = remove(c[field], segments[1:])
+		case ok:
+			cleared := make([]interface{}, 0)
+			for _, item := range items {
+				cleared = append(cleared, remove(item, segments[1:]))
+			}
+			c[field] = cleared
+		}
+		return c
+	}
+
+	return top, remove(data, segments).(map[string]interface{})[top]
+}
+
+func arrayItems(v interface{}) []interface{} {
+	switch value := v.(type) {
+	case []interface{}:
+		return value
+	case []string:
+		items := make([]interface{}, 0)
+		for _, s := range value {
+			items = append(items, s)
+		}
+		return items
+	}
+	return nil
+}
+
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// A reference to an entity
+type dependant struct {
+	Source string
+	Key    string
+}
+
+// Stream tracking references to entity, into entity group
+func (app *App) referencesStream(econf *EntityConf, accid, group, id string) string {
+	return EntityStream(app.tenant(econf, accid), group, ReferencesEntity, econf.Name+"_"+id)
+}
+
+// Entities referencing entity, in reference order
+func (app *App) dependants(econf *EntityConf, accid, group, id string) []dependant {
+	return app.streamDependants(app.referencesStream(econf, accid, group, id))
+}
+
+func (app *App) streamDependants(stream string) []dependant {
+	deps := make([]dependant, 0)
+	ch, _ := app.Store.Range(stream)
+	for e := range ch {
+		var d dependant
+		d.Source, _ = e.GetData()[ReferenceSourceKey].(string)
+		d.Key, _ = e.GetData()[ReferenceKey].(string)
+
+		switch e.GetType() {
+		case ReferenceAddedEvent:
+			deps = append(deps, d)
+		case ReferenceRemovedEvent:
+			for i, dep := range deps {
+				if dep == d {
+					deps = append(deps[:i], deps[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// Track references added and removed by stored event, deleted
+// entities do not reference anymore.
+func (app *App) trackReferences(econf *EntityConf, accid, source string, prior, entity *Entity) error {
+	for _, r := range econf.EntityReferences {
+		target, ok := app.Entities[r.Entity]
+		if !ok {
+			continue
+		}
+
+		old := []string{}
+		if !prior.Deleted {
+			values, _ := r.values(prior)
+			old = referenceIDs(values)
+		}
+		current := []string{}
+		if !entity.Deleted {
+			values, _ := r.values(entity)
+			current = referenceIDs(values)
+		}
+
+		for _, v := range current {
+			if v != "" && !contains(old, v) {
+				group := app.referenceGroup(target, accid, entity.Group, v)
+				err := app.storeReference(target, accid, group, v, ReferenceAddedEvent, source, r.Key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for _, v := range old {
+			if v != "" && !contains(current, v) {
+				group := app.referenceGroup(target, accid, entity.Group, v)
+				err := app.storeReference(target, accid, group, v, ReferenceRemovedEvent, source, r.Key)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) storeReference(target *EntityConf, accid, group, id, eventType, source, key string) error {
+	ev := NewEvent("", eventType, map[string]interface{}{ReferenceSourceKey: source, ReferenceKey: key})
+	ev.AccountID = app.tenant(target, accid)
+	ev.Group = group
+	ev.Entity = ReferencesEntity
+	ev.EntityID = target.Name + "_" + id
+	_, err := app.Store.Store(ev, StoreOptions{})
+	if err != nil {
+		return errors.New("Failed to track reference to " + target.Name + " " + id + " from " + source + ": " + err.Error())
+	}
+	return nil
+}
+
+// Referenced entity of references stream
+type referenced struct {
+	econf *EntityConf
+	accid string
+	group string
+	id    string
+}
+
+// Rebuild references of entity from entity states of app log, references
+// tracked into streams of referenced entities are added or removed.
+func (app *App) RebuildReferences(entity string) error {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	econf, ok := app.Entities[entity]
+	if !ok {
+		return InvalidEntityError
+	}
+
+	// entity streams and streams of referenced entities, by first event order
+	categories := map[string]bool{econf.Category(): true}
+	for _, r := range econf.EntityReferences {
+		if target, ok := app.Entities[r.Entity]; ok {
+			categories[target.Category()] = true
+		}
+	}
+	streams := make([]string, 0)
+	targets := make(map[string]referenced)
+	seen := make(map[string]bool)
+	for e := range app.scan(app.MainLog) {
+		if !categories[e.Entity] {
+			continue
+		}
+		name := app.entityName(e.Entity)
+		stream := app.stream(app.Entities[name], e.AccountID, e.Group, e.EntityID)
+		if seen[stream] {
+			continue
+		}
+		seen[stream] = true
+
+		if e.Entity == econf.Category() {
+			streams = append(streams, stream)
+		}
+		for _, r := range econf.EntityReferences {
+			if r.Entity == name {
+				target := app.Entities[name]
+				targets[app.referencesStream(target, e.AccountID, e.Group, e.EntityID)] = referenced{target, e.AccountID, e.Group, e.EntityID}
+				break
+			}
+		}
+	}
+
+	desired := make(map[string][]dependant)
+	for _, stream := range streams {
+		accid, group, _, id := app.ParseStream(stream)
+		state, _, err := app.ScopedEntity(accid, group, entity, id)
+		if err != nil {
+			return err
+		}
+		if state.Deleted {
+			continue
+		}
+
+		for _, r := range econf.EntityReferences {
+			target, ok := app.Entities[r.Entity]
+			if !ok {
+				continue
+			}
+			values, _ := r.values(state)
+			for _, v := range referenceIDs(values) {
+				if v == "" {
+					continue
+				}
+				tgroup := app.referenceGroup(target, accid, group, v)
+				rs := app.referencesStream(target, accid, tgroup, v)
+				d := dependant{stream, r.Key}
+				if !containsDependant(desired[rs], d) {
+					desired[rs] = append(desired[rs], d)
+				}
+				targets[rs] = referenced{target, accid, tgroup, v}
+			}
+		}
+	}
+
+	for rs, t := range targets {
+		want := append([]dependant{}, desired[rs]...)
+		for _, d := range app.streamDependants(rs) {
+			if _, _, name, _ := app.ParseStream(d.Source); name != entity {
+				continue
+			}
+			// tracked once, duplicates are removed
+			if i := indexDependant(want, d); i >= 0 {
+				want = append(want[:i], want[i+1:]...)
+				continue
+			}
+			err := app.storeReference(t.econf, t.accid, t.group, t.id, ReferenceRemovedEvent, d.Source, d.Key)
+			if err != nil {
+				return err
+			}
+		}
+		for _, d := range want {
+			err := app.storeReference(t.econf, t.accid, t.group, t.id, ReferenceAddedEvent, d.Source, d.Key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func indexDependant(deps []dependant, d dependant) int {
+	for i, dep := range deps {
+		if dep == d {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsDependant(deps []dependant, d dependant) bool {
+	return indexDependant(deps, d) >= 0
+}
+
+// Rebuild references of entity, admin only
+func ReferencesRebuildHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+
+	entity := c.Param("entity")
+	err = runningApp.RebuildReferences(entity)
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(200, map[string]string{"entity": entity, "action": RebuiltOpt})
+}
+
+// Reference of dependant with delete action, if any
+func (app *App) dependantReference(econf *EntityConf, d dependant) (*EntityConf, EntityReference, bool) {
+	_, _, depName, _ := app.ParseStream(d.Source)
+	depConf, ok := app.Entities[depName]
+	if !ok {
+		return nil, EntityReference{}, false
+	}
+	for _, r := range depConf.EntityReferences {
+		if r.Entity == econf.Name && r.Key == d.Key && r.OnDelete != NoAction {
+			return depConf, r, true
+		}
+	}
+	return nil, EntityReference{}, false
+}
+
+// Delete actions of references to entity, checked before the delete is
+// stored. Restricted references fail the delete, dependants are deleted or
+// nullified as the user deleting the entity. App lock should be held.
+func (app *App) deleteActions(econf *EntityConf, accid, group, id, stream, userid, role string) ([]*command, error) {
+	app.deleting[stream] = true
+
+	actions := make([]*command, 0)
+	for _, d := range app.dependants(econf, accid, group, id) {
+		// dependant being deleted by cascade
+		if app.deleting[d.Source] {
+			continue
+		}
+		depConf, r, ok := app.dependantReference(econf, d)
+		if !ok {
+			continue
+		}
+		if r.OnDelete == RestrictDelete {
+			return nil, ReferencedError{econf.Name, id, d.Source, d.Key}
+		}
+
+		depAcc, depGroup, depName, depID := app.ParseStream(d.Source)
+		dep, _, err := app.ScopedEntity(depAcc, depGroup, depName, depID)
+		if err != nil {
+			return nil, err
+		}
+
+		ch := NewCRUDHandler(depConf.Name)
+		var ev *Event
+		switch r.OnDelete {
+		case CascadeDelete:
+			if dep.Deleted {
+				continue
+			}
+			ev = NewEvent("", ch.DeletedEvent(), map[string]interface{}{})
+		case NullifyDelete:
+			// only removed from lists of references
+			field, value := r.nullify(dep.Data, id)
+			ev = NewEvent("", ch.UpdateEvent(), map[string]interface{}{field: value})
+		default:
+			continue
+		}
+
+		if _, ok := depConf.EventHandlers[ev.GetType()]; !ok {
+			return nil, errors.New("Failed to " + r.OnDelete + " " + d.Source + ": " + ev.GetType() + " not handled")
+		}
+
+		ev.Entity = depConf.Name
+		ev.EntityID = depID
+		ev.Group = depGroup
+		cmd, err := app.prepare(depConf.Name, depID, depAcc, userid, role, ev)
+		if _, restricted := err.(ReferencedError); restricted {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.New("Failed to " + r.OnDelete + " " + d.Source + ": " + err.Error())
+		}
+		actions = append(actions, cmd)
+	}
+	return actions, nil
+}
@@ -0,0 +1,177 @@
+package gocqrs_test
+
+import (
+	"errors"
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+type node struct {
+	Name string      `json:"name"`
+	Ref  interface{} `json:"ref"`
+}
+
+// Parents referenced by kids on cascade and notes on nullify,
+// kids referenced by pins on restrict
+func newReferencesApp() (*gocqrs.App, func(entity, eventType, id string, ref interface{}) error) {
+	app, _ := newTestApp()
+	for _, name := range []string{"parents", "kids", "notes", "pins"} {
+		conf := gocqrs.NewEntityConf(name)
+		conf.AddCRUD(false)
+		conf.SetBaseStruct(node{})
+		switch name {
+		case "kids":
+			conf.ReferenceOnDelete("parents", "ref", false, gocqrs.CascadeDelete)
+		case "notes":
+			conf.ReferenceOnDelete("parents", "ref", true, gocqrs.NullifyDelete)
+		case "pins":
+			conf.ReferenceOnDelete("kids", "ref", false, gocqrs.RestrictDelete)
+		}
+		app.RegisterEntity(conf)
+	}
+
+	do := func(entity, eventType, id string, ref interface{}) error {
+		data := map[string]interface{}{"name": id}
+		if ref != nil {
+			data["ref"] = ref
+		}
+		ev := gocqrs.NewEvent("", eventType, data)
+		ev.Entity = entity
+		ev.EntityID = id
+		_, _, err := app.HandleEvent(entity, id, "acc", "tester", "", ev, 0)
+		return err
+	}
+	return app, do
+}
+
+func TestDeleteActions(t *testing.T) {
+	app, do := newReferencesApp()
+	for _, step := range [][]string{
+		{"parents", "ParentsCreated", "p1", ""},
+		{"kids", "KidsCreated", "k1", "p1"},
+		{"notes", "NotesCreated", "n1", "p1"},
+		{"pins", "PinsCreated", "x1", "k1"},
+	} {
+		var ref interface{}
+		if step[3] != "" {
+			ref = step[3]
+		}
+		if err := do(step[0], step[1], step[2], ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pin restricts cascaded kid
+	err := do("parents", "ParentsDeleted", "p1", nil)
+	if _, ok := err.(gocqrs.ReferencedError); !ok {
+		t.Fatal("expected referenced error, got", err)
+	}
+	if p, _, _ := app.AccountEntity("acc", "parents", "p1"); p.Deleted {
+		t.Fatal("restricted parent should not be deleted")
+	}
+
+	if err = do("pins", "PinsDeleted", "x1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = do("parents", "ParentsDeleted", "p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	k, _, _ := app.AccountEntity("acc", "kids", "k1")
+	n, _, _ := app.AccountEntity("acc", "notes", "n1")
+	if !k.Deleted || n.Data["ref"] != nil {
+		t.Fatal("kid should be deleted and note reference nullified", k, n)
+	}
+
+	// delete actions follow deleted parent
+	ch, _ := app.Store.Range(app.MainLog)
+	types := make([]string, 0)
+	for e := range ch {
+		types = append(types, e.GetType())
+	}
+	if len(types) < 3 || types[len(types)-3] != "ParentsDeleted" {
+		t.Fatal("delete actions stored before parent delete", types)
+	}
+}
+
+func TestRebuildReferences(t *testing.T) {
+	app, do := newReferencesApp()
+	if err := do("parents", "ParentsCreated", "p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := do("kids", "KidsCreated", "k1", "p1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// lost tracking, stale reference removed and current one kept
+	stream := gocqrs.EntityStream("acc", "", gocqrs.ReferencesEntity, "parents_p1")
+	for source, eventType := range map[string]string{"acc.kids-k1": gocqrs.ReferenceRemovedEvent, "acc.kids-k9": gocqrs.ReferenceAddedEvent} {
+		ev := gocqrs.NewEvent("", eventType, map[string]interface{}{gocqrs.ReferenceSourceKey: source, gocqrs.ReferenceKey: "ref"})
+		ev.AccountID = "acc"
+		ev.Entity = gocqrs.ReferencesEntity
+		ev.EntityID = "parents_p1"
+		if _, err := app.Store.Store(ev, gocqrs.StoreOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := app.RebuildReferences("kids")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := make(map[string]int)
+	ch, _ := app.Store.Range(stream)
+	for e := range ch {
+		source := e.GetData()[gocqrs.ReferenceSourceKey].(string)
+		switch e.GetType() {
+		case gocqrs.ReferenceAddedEvent:
+			sources[source]++
+		case gocqrs.ReferenceRemovedEvent:
+			sources[source]--
+		}
+	}
+	if sources["acc.kids-k1"] != 1 || sources["acc.kids-k9"] != 0 {
+		t.Fatal("unexpected rebuilt references", sources)
+	}
+
+	// rebuilt reference restricts parent delete through cascade
+	if err = do("pins", "PinsCreated", "x1", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = do("parents", "ParentsDeleted", "p1", nil); err == nil {
+		t.Fatal("rebuilt cascade reference should be restricted")
+	}
+}
+
+func TestDeleteActionsChecked(t *testing.T) {
+	app, do := newReferencesApp()
+	app.Entities["kids"].AddInvariant(gocqrs.NewInvariant("locked", func(s gocqrs.Scope, prior *gocqrs.Entity, ev gocqrs.Eventer, entity *gocqrs.Entity) error {
+		if entity.Deleted && entity.ID == "locked" {
+			return errors.New("locked kid can not be deleted")
+		}
+		return nil
+	}))
+	for _, step := range [][]string{
+		{"parents", "ParentsCreated", "p1", ""},
+		{"notes", "NotesCreated", "n1", "p1"},
+		{"kids", "KidsCreated", "locked", "p1"},
+	} {
+		var ref interface{}
+		if step[3] != "" {
+			ref = step[3]
+		}
+		if err := do(step[0], step[1], step[2], ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// cascaded kid fails its invariant, nothing is stored
+	if err := do("parents", "ParentsDeleted", "p1", nil); err == nil {
+		t.Fatal("expected cascade to fail parent delete")
+	}
+	p, _, _ := app.AccountEntity("acc", "parents", "p1")
+	n, _, _ := app.AccountEntity("acc", "notes", "n1")
+	if p.Deleted || n.Data["ref"] != "p1" {
+		t.Fatal("parent delete should not be stored", p, n)
+	}
+}
@@ -1,14 +1,49 @@
 package gocqrs
 
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Role permissions are patterns, matched as globs (see path.Match):
+//
+//	OrderPlaced   exact event type
+//	Order*        any event starting with Order
+//	*Deleted      any event ending with Deleted
+//	orders:*      any event of entity orders
+//	orders:*Deleted
+//
+// An empty Allowed list allows every event, an empty Entities list
+// allows reading every entity. NotAllowed always wins over Allowed.
+//
+// Roles inherit permissions from Parents: Allowed and Entities are the
+// union of the role and its parents (if any of them allows all, the role
+// allows all), NotAllowed is the union of every deny in the hierarchy,
+// so a child can never allow what a parent denies. Empty lists of roles
+// with parents only inherit, the role gets its parents permissions.
 type Role struct {
 	Name string `json:"name"`
 
 	// Access to entities
-	Entities []string
+	Entities []string `json:"entities"`
 	// events and read cmd that can be executed ,default ALL
 	Allowed []string `json:"allowed"`
 
 	NotAllowed []string `json:"noAllowed"`
+
+	// parent roles to inherit permissions from
+	Parents []string `json:"parents,omitempty"`
+
+	// defined in code, can't be changed at runtime
+	System bool `json:"system"`
+
+	// resolved permissions, set by App.AddRoles
+	resolved bool
+	allowed  []string
+	denied   []string
+	entities []string
 }
 
 func NewRole(r string) *Role {
@@ -23,13 +58,18 @@ func NewRole(r string) *Role {
 }
 
 func (r *Role) CanRead(e string) bool {
+	entities := r.Entities
+	if r.resolved {
+		entities = r.entities
+	}
+
 	allowed := false
-	if len(r.Entities) == 0 {
+	if len(entities) == 0 {
 		allowed = true
 	}
 
-	for _, entity := range r.Entities {
-		if entity == e {
+	for _, entity := range entities {
+		if match(entity, e) {
 			allowed = true
 			break
 		}
@@ -38,22 +78,33 @@ func (r *Role) CanRead(e string) bool {
 	return allowed
 }
 
+// Check if role can execute event, any entity
 func (r *Role) Can(e string) bool {
+	return r.CanDo("", e)
+}
+
+// Check if role can execute event over entity
+func (r *Role) CanDo(entity, e string) bool {
+	allowedEvents, deniedEvents := r.Allowed, r.NotAllowed
+	if r.resolved {
+		allowedEvents, deniedEvents = r.allowed, r.denied
+	}
+
 	allowed := false
 
-	if len(r.Allowed) == 0 {
+	if len(allowedEvents) == 0 {
 		allowed = true
 	} else {
-		for _, ae := range r.Allowed {
-			if ae == e {
+		for _, ae := range allowedEvents {
+			if matchEvent(ae, entity, e) {
 				allowed = true
 				break
 			}
 		}
 	}
 
-	for _, de := range r.NotAllowed {
-		if e == de {
+	for _, de := range deniedEvents {
+		if matchEvent(de, entity, e) {
 			allowed = false
 			break
 		}
@@ -62,6 +113,117 @@ func (r *Role) Can(e string) bool {
 	return allowed
 }
 
+// Check role patterns are valid
+func (r *Role) Valid() error {
+	patterns := append(append([]string{}, r.Allowed...), r.NotAllowed...)
+	patterns = append(patterns, r.Entities...)
+	for _, p := range patterns {
+		for _, part := range strings.SplitN(p, ":", 2) {
+			_, err := path.Match(part, "")
+			if err != nil {
+				return errors.New("Invalid role pattern: " + r.Name + " - " + p)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Role) Inherit(parents ...string) {
+	for _, p := range parents {
+		r.Parents = append(r.Parents, p)
+	}
+}
+
+// Resolve permissions of every role through its parents,
+// fails if a parent does not exist or there is a cycle.
+func resolveRoles(roles map[string]Role) error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		chain = append(chain, name)
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return errors.New("Role inheritance cycle: " + strings.Join(chain, " -> "))
+		}
+
+		r, ok := roles[name]
+		if !ok {
+			return errors.New("Invalid parent role: " + strings.Join(chain, " -> "))
+		}
+		state[name] = visiting
+
+		// roles with parents and empty lists only inherit
+		allowAll := len(r.Allowed) == 0 && len(r.Parents) == 0
+		readAll := len(r.Entities) == 0 && len(r.Parents) == 0
+		r.allowed = append([]string{}, r.Allowed...)
+		r.denied = append([]string{}, r.NotAllowed...)
+		r.entities = append([]string{}, r.Entities...)
+
+		for _, p := range r.Parents {
+			err := resolve(p, chain)
+			if err != nil {
+				return err
+			}
+			parent := roles[p]
+			if len(parent.allowed) == 0 {
+				allowAll = true
+			}
+			if len(parent.entities) == 0 {
+				readAll = true
+			}
+			r.allowed = append(r.allowed, parent.allowed...)
+			r.denied = append(r.denied, parent.denied...)
+			r.entities = append(r.entities, parent.entities...)
+		}
+
+		if allowAll {
+			r.allowed = []string{}
+		}
+		if readAll {
+			r.entities = []string{}
+		}
+		r.resolved = true
+
+		roles[name] = r
+		state[name] = done
+		return nil
+	}
+
+	for name, _ := range roles {
+		err := resolve(name, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchEvent(pattern, entity, e string) bool {
+	parts := strings.SplitN(pattern, ":", 2)
+	if len(parts) == 2 {
+		if entity == "" || !match(parts[0], entity) {
+			return false
+		}
+		pattern = parts[1]
+	}
+	return match(pattern, e)
+}
+
+func match(pattern, s string) bool {
+	if pattern == s {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
 func (r *Role) Allow(cmd ...string) {
 	for _, c := range cmd {
 		r.Allowed = append(r.Allowed, c)
@@ -73,3 +235,36 @@ func (r *Role) NotAllow(cmd ...string) {
 		r.NotAllowed = append(r.NotAllowed, c)
 	}
 }
+
+// Permissions of a role over an entity
+type EntityPermissions struct {
+	Read   bool     `json:"read"`
+	Events []string `json:"events"`
+}
+
+// What a role can actually do against registered entities
+type EffectiveRole struct {
+	Name     string                       `json:"name"`
+	Entities map[string]EntityPermissions `json:"entities"`
+}
+
+func (app *App) EffectiveRole(r Role) EffectiveRole {
+	var er EffectiveRole
+	er.Name = r.Name
+	er.Entities = make(map[string]EntityPermissions)
+
+	for name, conf := range app.Entities {
+		var ep EntityPermissions
+		ep.Read = r.CanRead(name)
+		ep.Events = make([]string, 0)
+		for event, _ := range conf.EventHandlers {
+			if r.CanDo(name, event) {
+				ep.Events = append(ep.Events, event)
+			}
+		}
+		sort.Strings(ep.Events)
+		er.Entities[name] = ep
+	}
+
+	return er
+}
@@ -0,0 +1,70 @@
+package gocqrs
+
+import (
+	"testing"
+)
+
+func TestResolveRolesUnion(t *testing.T) {
+	parent := NewRole("parent")
+	parent.Allowed = []string{"orders:*"}
+	parent.Entities = []string{"orders"}
+	parent.NotAllowed = []string{"*Deleted"}
+
+	// only inherits parent permissions
+	inherited := NewRole("inherited")
+	inherited.Inherit("parent")
+
+	// no Allowed list nor parents, allows every event
+	open := NewRole("open")
+	open.NotAllowed = []string{"*Deleted"}
+
+	child := NewRole("child")
+	child.Allowed = []string{"users:UserCreated"}
+	child.Entities = []string{"users"}
+	child.Inherit("parent")
+
+	roles := map[string]Role{"parent": *parent, "inherited": *inherited, "open": *open, "child": *child}
+	err := resolveRoles(roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := roles["open"]
+	if !o.CanDo("users", "UserCreated") || !o.CanRead("users") {
+		t.Fatal("role without Allowed list should allow every event and entity")
+	}
+	if o.CanDo("orders", "OrderDeleted") {
+		t.Fatal("deny should win")
+	}
+
+	i := roles["inherited"]
+	if !i.CanDo("orders", "OrderPlaced") || !i.CanRead("orders") {
+		t.Fatal("inheriting role should allow parent events and entities")
+	}
+	if i.CanDo("users", "UserCreated") || i.CanRead("users") || i.CanDo("orders", "OrderDeleted") {
+		t.Fatal("inheriting role should not allow more than parent")
+	}
+
+	c := roles["child"]
+	if !c.CanDo("users", "UserCreated") || !c.CanDo("orders", "OrderPlaced") {
+		t.Fatal("child should allow union of its and parent events")
+	}
+	if c.CanDo("users", "UserUpdated") || c.CanRead("products") {
+		t.Fatal("child should not allow outside union")
+	}
+	if !c.CanRead("users") || !c.CanRead("orders") {
+		t.Fatal("child should read union of entities")
+	}
+}
+
+func TestResolveRolesCycle(t *testing.T) {
+	a := NewRole("a")
+	a.Inherit("b")
+	b := NewRole("b")
+	b.Inherit("a")
+
+	err := resolveRoles(map[string]Role{"a": *a, "b": *b})
+	if err == nil {
+		t.Fatal("cycle should fail")
+	}
+}
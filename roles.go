@@ -0,0 +1,218 @@
+package gocqrs
+
+import (
+	"errors"
+	"log"
+)
+
+const (
+	RoleEntity = "roles"
+
+	RoleCreatedEvent            = "RoleCreated"
+	RolePermissionsChangedEvent = "RolePermissionsChanged"
+	RoleDeletedEvent            = "RoleDeleted"
+)
+
+var (
+	SystemRoleError = errors.New("System role can't be changed")
+)
+
+// Role permissions stored as events, RoleCreated and RolePermissionsChanged
+// carry the Role fields (allowed, noAllowed, entities, parents).
+type RoleEventHandler struct {
+}
+
+func (rh RoleEventHandler) EventName() []string {
+	return []string{
+		RoleCreatedEvent,
+		RolePermissionsChangedEvent,
+		RoleDeletedEvent,
+	}
+}
+
+func (rh RoleEventHandler) Handle(id, accid, userid, role string, event Eventer, entity *Entity, replay bool) (StoreOptions, error) {
+	var opt StoreOptions
+	var err error
+
+	if !replay {
+		current, exist := runningApp.GetRole(id)
+		if exist && current.System {
+			return opt, SystemRoleError
+		}
+	}
+
+	switch event.GetType() {
+	case RoleCreatedEvent:
+		var r Role
+		DecodeEvent(event, &r)
+		r.Name = id
+		r.System = false
+		opt.Create = true
+		entity.Data = ToMap(r)
+	case RolePermissionsChangedEvent:
+		if entity.Deleted {
+			return opt, EntityDeleted
+		}
+		for _, k := range []string{"allowed", "noAllowed", "entities", "parents"} {
+			if event.Has(k) {
+				entity.Data[k] = event.GetData()[k]
+			}
+		}
+	case RoleDeletedEvent:
+		entity.Deleted = true
+	}
+
+	if !replay {
+		err = runningApp.checkRole(id, entity)
+	}
+
+	return opt, err
+}
+
+func (rh RoleEventHandler) CheckBase(e Eventer) bool {
+	switch e.GetType() {
+	case RoleCreatedEvent, RolePermissionsChangedEvent:
+		return true
+	}
+	return false
+}
+
+// Manage roles at runtime as events, roles added with AddRoles are system
+// roles and can't be changed.
+func (app *App) ManageRoles(evh ...EventHandler) {
+	roleEntity := NewEntityConf(RoleEntity)
+	roleEntity.Global = true
+
+	for _, h := range evh {
+		roleEntity.AddEventHandler(h)
+	}
+	roleEntity.AddEventHandler(RoleEventHandler{})
+	roleEntity.SetBaseStruct(Role{})
+
+	app.RegisterEntity(roleEntity)
+}
+
+func (app *App) GetRole(name string) (Role, bool) {
+	app.rolesLock.RLock()
+	defer app.rolesLock.RUnlock()
+	r, ok := app.Roles[name]
+	return r, ok
+}
+
+// Check new role state keeps hierarchy valid
+func (app *App) checkRole(id string, entity *Entity) error {
+	app.rolesLock.RLock()
+	roles := make(map[string]Role)
+	for n, r := range app.Roles {
+		roles[n] = r
+	}
+	app.rolesLock.RUnlock()
+
+	if entity.Deleted {
+		delete(roles, id)
+	} else {
+		var r Role
+		entity.Decode(&r)
+		r.Name = id
+		err := r.Valid()
+		if err != nil {
+			return err
+		}
+		roles[id] = r
+	}
+
+	return resolveRoles(roles)
+}
+
+// Reload role from its stream into App.Roles
+func (app *App) reloadRole(id string) error {
+	entity, _, err := app.Entity(RoleEntity, id)
+	if err != nil {
+		return err
+	}
+
+	app.rolesLock.Lock()
+	defer app.rolesLock.Unlock()
+
+	current, exist := app.Roles[id]
+	if exist && current.System {
+		return SystemRoleError
+	}
+
+	roles := make(map[string]Role)
+	for n, r := range app.Roles {
+		roles[n] = r
+	}
+
+	if entity.Deleted {
+		delete(roles, id)
+	} else {
+		var r Role
+		entity.Decode(&r)
+		r.Name = id
+		roles[id] = r
+	}
+
+	err = resolveRoles(roles)
+	if err != nil {
+		return err
+	}
+	app.Roles = roles
+
+	return nil
+}
+
+// Viewer over app log, reloads roles when role events arrive
+type roleViewer struct {
+	app     *App
+	version uint64
+}
+
+func (rv *roleViewer) Init(params map[string]string, dev bool) {}
+
+func (rv *roleViewer) Purge() error {
+	rv.version = 0
+	return nil
+}
+
+func (rv *roleViewer) Status() (uint64, error) {
+	return rv.version, nil
+}
+
+func (rv *roleViewer) Stream() string {
+	return rv.app.MainLog
+}
+
+func (rv *roleViewer) Rebuild() error {
+	return rv.Purge()
+}
+
+func (rv *roleViewer) Apply(event Event) error {
+	rv.version = event.EventVersion
+	if event.Entity != RoleEntity {
+		return nil
+	}
+
+	err := rv.app.reloadRole(event.EntityID)
+	if err != nil {
+		log.Println("Failed to reload role", event.EntityID, ":", err)
+	}
+	return nil
+}
+
+// Load stored roles and keep them updated
+func (app *App) watchRoles() {
+	rv := &roleViewer{app: app}
+
+	// first load is done before serving
+	version, err := app.Store.Version(app.MainLog)
+	if err == nil {
+		for e := range app.Store.Scan(app.MainLog, 0, version) {
+			rv.Apply(e)
+		}
+	}
+
+	v := NewView(RoleEntity, rv)
+	v.Store = app.Store
+	go v.Run("", nil, false)
+}
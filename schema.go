@@ -0,0 +1,525 @@
+package gocqrs
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/asaskevich/govalidator.v4"
+	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// JSON Schema, draft 2020-12 subset. Supported keywords:
+// type, enum, const, minimum, maximum, exclusiveMinimum, exclusiveMaximum,
+// multipleOf, minLength, maxLength, pattern, format, items, minItems,
+// maxItems, uniqueItems, properties, required, additionalProperties,
+// allOf, anyOf, oneOf, not, $defs and $ref to #/$defs/name.
+// Boolean schemas (true/false) are supported.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	ID          string             `json:"$id,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Defs        map[string]*Schema `json:"$defs,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+
+	Type  SchemaTypes   `json:"type,omitempty"`
+	Enum  []interface{} `json:"enum,omitempty"`
+	Const interface{}   `json:"const,omitempty"`
+
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+	MultipleOf       *float64 `json:"multipleOf,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	Items       *Schema `json:"items,omitempty"`
+	MinItems    *int    `json:"minItems,omitempty"`
+	MaxItems    *int    `json:"maxItems,omitempty"`
+	UniqueItems bool    `json:"uniqueItems,omitempty"`
+
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+
+	AllOf []*Schema `json:"allOf,omitempty"`
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+	OneOf []*Schema `json:"oneOf,omitempty"`
+	Not   *Schema   `json:"not,omitempty"`
+
+	// boolean schema
+	always *bool
+}
+
+// Type keyword, single type or list of types
+type SchemaTypes []string
+
+func (st *SchemaTypes) UnmarshalJSON(b []byte) error {
+	var t string
+	if err := json.Unmarshal(b, &t); err == nil {
+		*st = SchemaTypes{t}
+		return nil
+	}
+
+	var ts []string
+	err := json.Unmarshal(b, &ts)
+	if err != nil {
+		return errors.New("Invalid schema type")
+	}
+	*st = SchemaTypes(ts)
+	return nil
+}
+
+func (st SchemaTypes) MarshalJSON() ([]byte, error) {
+	if len(st) == 1 {
+		return json.Marshal(st[0])
+	}
+	return json.Marshal([]string(st))
+}
+
+type schema Schema
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var always bool
+	if err := json.Unmarshal(b, &always); err == nil {
+		*s = Schema{always: &always}
+		return nil
+	}
+
+	var sc schema
+	err := json.Unmarshal(b, &sc)
+	if err != nil {
+		return err
+	}
+	*s = Schema(sc)
+
+	if s.Pattern != "" {
+		_, err = pattern(s.Pattern)
+	}
+	return err
+}
+
+func (s Schema) MarshalJSON() ([]byte, error) {
+	if s.always != nil {
+		return json.Marshal(*s.always)
+	}
+	return json.Marshal(schema(s))
+}
+
+// Schema accepting or rejecting any value
+func BoolSchema(b bool) *Schema {
+	return &Schema{always: &b}
+}
+
+func ParseSchema(b []byte) (*Schema, error) {
+	var s Schema
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, errors.New("Invalid schema: " + err.Error())
+	}
+	err = s.checkRefs()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Parse schema, invalid schemas are fatal
+func MustParseSchema(s string) *Schema {
+	schema, err := ParseSchema([]byte(s))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return schema
+}
+
+// Validate entity state with schema
+func (e *EntityConf) SetSchema(s *Schema) *EntityConf {
+	e.Schema = s
+	return e
+}
+
+// Validate data of event type with schema
+func (e *EntityConf) SetEventSchema(event string, s *Schema) *EntityConf {
+	e.EventSchemas[event] = s
+	return e
+}
+
+// Check value with schema, returns ValidationErrors with every failed rule
+func (s *Schema) Check(v interface{}) error {
+	err := s.checkRefs()
+	if err != nil {
+		return err
+	}
+
+	// work with JSON values, as stored
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	err = json.Unmarshal(b, &value)
+	if err != nil {
+		return err
+	}
+
+	errs := make(ValidationErrors, 0)
+	s.validate(s, "", value, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s *Schema) valid(root *Schema, path string, v interface{}) bool {
+	errs := make(ValidationErrors, 0)
+	s.validate(root, path, v, &errs)
+	return len(errs) == 0
+}
+
+func (s *Schema) validate(root *Schema, path string, v interface{}, errs *ValidationErrors) {
+	fail := func(rule, msg string) {
+		*errs = append(*errs, ValidationError{Field: path, Rule: rule, Message: msg})
+	}
+
+	if s == nil {
+		return
+	}
+	if s.always != nil {
+		if !*s.always {
+			fail("false", "not allowed")
+		}
+		return
+	}
+
+	if s.Ref != "" {
+		ref, err := root.resolve(s.Ref)
+		if err != nil {
+			fail("$ref", err.Error())
+			return
+		}
+		ref.validate(root, path, v, errs)
+	}
+
+	if len(s.Type) > 0 {
+		matched := false
+		for _, t := range s.Type {
+			if isType(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("type", "should be "+strings.Join(s.Type, " or "))
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(jsonValue(e), v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "should be one of allowed values")
+		}
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(jsonValue(s.Const), v) {
+		fail("const", "should be constant value")
+	}
+
+	switch value := v.(type) {
+	case float64:
+		s.validateNumber(value, fail)
+	case string:
+		s.validateString(value, fail)
+	case []interface{}:
+		for i, item := range value {
+			s.Items.validate(root, path+"["+strconv.Itoa(i)+"]", item, errs)
+		}
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			fail("minItems", "should have at least "+strconv.Itoa(*s.MinItems)+" items")
+		}
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			fail("maxItems", "should have at most "+strconv.Itoa(*s.MaxItems)+" items")
+		}
+		if s.UniqueItems && !unique(value) {
+			fail("uniqueItems", "should have unique items")
+		}
+	case map[string]interface{}:
+		for _, r := range s.Required {
+			if _, ok := value[r]; !ok {
+				*errs = append(*errs, ValidationError{Field: fieldPath(path, r), Rule: "required", Message: "is required"})
+			}
+		}
+		// sorted, errors are reported always in same order
+		keys := make([]string, 0, len(value))
+		for k, _ := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps, ok := s.Properties[k]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			ps.validate(root, fieldPath(path, k), value[k], errs)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(root, path, v, errs)
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.valid(root, path, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("anyOf", "should match at least one schema")
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if sub.valid(root, path, v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("oneOf", "should match exactly one schema")
+		}
+	}
+
+	if s.Not != nil && s.Not.valid(root, path, v) {
+		fail("not", "should not match schema")
+	}
+}
+
+func (s *Schema) validateNumber(n float64, fail func(rule, msg string)) {
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	if s.Minimum != nil && n < *s.Minimum {
+		fail("minimum", "should be >= "+format(*s.Minimum))
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		fail("maximum", "should be <= "+format(*s.Maximum))
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		fail("exclusiveMinimum", "should be > "+format(*s.ExclusiveMinimum))
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		fail("exclusiveMaximum", "should be < "+format(*s.ExclusiveMaximum))
+	}
+	if s.MultipleOf != nil && *s.MultipleOf > 0 {
+		q := n / *s.MultipleOf
+		if math.Abs(q-math.Floor(q+0.5)) > 1e-9 {
+			fail("multipleOf", "should be multiple of "+format(*s.MultipleOf))
+		}
+	}
+}
+
+func (s *Schema) validateString(str string, fail func(rule, msg string)) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		fail("minLength", "should have at least "+strconv.Itoa(*s.MinLength)+" characters")
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		fail("maxLength", "should have at most "+strconv.Itoa(*s.MaxLength)+" characters")
+	}
+	if s.Pattern != "" {
+		re, err := pattern(s.Pattern)
+		if err != nil || !re.MatchString(str) {
+			fail("pattern", "should match pattern "+s.Pattern)
+		}
+	}
+	if s.Format != "" && !validFormat(s.Format, str) {
+		fail("format", "should be valid "+s.Format)
+	}
+}
+
+// References followed back without reading into value never end validating,
+// every cycle goes through root schema or a definition.
+func (s *Schema) checkRefs() error {
+	if s == nil {
+		return nil
+	}
+
+	ref := s.circular(s, map[string]bool{"#": true})
+	for name, def := range s.Defs {
+		if ref != "" {
+			break
+		}
+		ref = def.circular(s, map[string]bool{"#/$defs/" + name: true})
+	}
+
+	if ref != "" {
+		return errors.New("Invalid schema: circular reference " + ref)
+	}
+	return nil
+}
+
+// First reference followed again on same value, refs are already followed
+func (s *Schema) circular(root *Schema, refs map[string]bool) string {
+	if s == nil {
+		return ""
+	}
+
+	if s.Ref != "" {
+		if refs[s.Ref] {
+			return s.Ref
+		}
+		ref, err := root.resolve(s.Ref)
+		if err == nil {
+			refs[s.Ref] = true
+			c := ref.circular(root, refs)
+			delete(refs, s.Ref)
+			if c != "" {
+				return c
+			}
+		}
+	}
+
+	subs := append([]*Schema{s.Not}, s.AllOf...)
+	subs = append(subs, s.AnyOf...)
+	subs = append(subs, s.OneOf...)
+	for _, sub := range subs {
+		if c := sub.circular(root, refs); c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// Resolve reference to root schema or its definitions
+func (s *Schema) resolve(ref string) (*Schema, error) {
+	if ref == "#" {
+		return s, nil
+	}
+
+	name := strings.TrimPrefix(ref, "#/$defs/")
+	if name != ref {
+		if def, ok := s.Defs[name]; ok {
+			return def, nil
+		}
+	}
+	return nil, errors.New("unresolved reference " + ref)
+}
+
+func isType(t string, v interface{}) bool {
+	switch v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		if t == "integer" {
+			f := v.(float64)
+			return f == math.Trunc(f)
+		}
+		return t == "number"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+// Unknown formats are only annotations
+func validFormat(format, s string) bool {
+	var err error
+	switch format {
+	case "email":
+		return govalidator.IsEmail(s)
+	case "uri", "url":
+		return govalidator.IsURL(s)
+	case "ipv4":
+		return govalidator.IsIPv4(s)
+	case "ipv6":
+		return govalidator.IsIPv6(s)
+	case "uuid":
+		return govalidator.IsUUID(s)
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, s)
+	case "date":
+		_, err = time.Parse("2006-01-02", s)
+	case "time":
+		_, err = time.Parse("15:04:05Z07:00", s)
+	}
+	return err == nil
+}
+
+func unique(items []interface{}) bool {
+	for i := range items {
+		for j := i + 1; j < len(items); j++ {
+			if reflect.DeepEqual(items[i], items[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Value as decoded from JSON, numbers are float64
+func jsonValue(v interface{}) interface{} {
+	var value interface{}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &value)
+	return value
+}
+
+func fieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+var (
+	patternsLock sync.Mutex
+	patterns     = make(map[string]*regexp.Regexp)
+)
+
+// Compiled schema patterns
+func pattern(p string) (*regexp.Regexp, error) {
+	patternsLock.Lock()
+	defer patternsLock.Unlock()
+
+	re, ok := patterns[p]
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, errors.New("Invalid schema pattern: " + p)
+	}
+	patterns[p] = re
+	return re, nil
+}
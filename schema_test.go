@@ -0,0 +1,52 @@
+package gocqrs_test
+
+import (
+	"github.com/diegogub/gocqrs"
+	"testing"
+)
+
+func TestSchemaRefCycle(t *testing.T) {
+	for _, s := range []string{
+		`{"$ref": "#"}`,
+		`{"$ref": "#/$defs/a", "$defs": {"a": {"$ref": "#/$defs/a"}}}`,
+		`{"$ref": "#/$defs/a", "$defs": {"a": {"allOf": [{"$ref": "#/$defs/b"}]}, "b": {"not": {"$ref": "#/$defs/a"}}}}`,
+	} {
+		_, err := gocqrs.ParseSchema([]byte(s))
+		if err == nil {
+			t.Fatal("circular reference should fail:", s)
+		}
+	}
+
+	// schemas built in code are checked on validation
+	s := &gocqrs.Schema{Ref: "#"}
+	if err := s.Check("pen"); err == nil {
+		t.Fatal("circular reference should fail on check")
+	}
+}
+
+func TestSchemaRecursiveRef(t *testing.T) {
+	// recursion into properties ends with value
+	s := gocqrs.MustParseSchema(`{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"name": {"type": "string"},
+			"children": {"type": "array", "items": {"$ref": "#"}}
+		}
+	}`)
+
+	tree := map[string]interface{}{
+		"name": "root",
+		"children": []interface{}{
+			map[string]interface{}{"name": "leaf", "children": []interface{}{}},
+		},
+	}
+	if err := s.Check(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	tree["children"] = []interface{}{map[string]interface{}{}}
+	if err := s.Check(tree); err == nil {
+		t.Fatal("nested child without name should fail")
+	}
+}
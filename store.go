@@ -1,7 +1,9 @@
 package gocqrs
 
 import (
+	"context"
 	"errors"
+	"gopkg.in/gin-gonic/gin.v1"
 )
 
 var (
@@ -18,6 +20,71 @@ type EventStore interface {
 	Scan(streamid string, from, to uint64) chan Event
 }
 
+// Stores able to push stream updates, channel receives new stream version
+// when stream advances and it's closed once context is done.
+type Notifier interface {
+	Notify(ctx context.Context, streamid string) (<-chan uint64, error)
+}
+
+// Stores keeping index streams can rebuild them from a log stream
+type Indexer interface {
+	RebuildIndexes(log string) error
+}
+
+const (
+	CategoryStreamPrefix  = "$ce-"
+	EventTypeStreamPrefix = "$et-"
+)
+
+// Index stream with all events of stream category, see EntityConf.Category
+func CategoryStream(category string) string {
+	return CategoryStreamPrefix + category
+}
+
+// Index stream with all events of type
+func EventTypeStream(t string) string {
+	return EventTypeStreamPrefix + t
+}
+
+// Index streams an event should be linked to
+func IndexStreams(e Eventer) []string {
+	return StreamIndexes(e.GetStream(), e.GetType())
+}
+
+// Index streams of event type stored into stream
+func StreamIndexes(stream, eventType string) []string {
+	_, _, category, _ := ParseStream(stream)
+	return []string{CategoryStream(category), EventTypeStream(eventType)}
+}
+
+// Rebuild index streams from app log, store should implement Indexer
+func (app *App) RebuildIndexes() error {
+	indexer, ok := app.Store.(Indexer)
+	if !ok {
+		return errors.New("Store does not keep index streams")
+	}
+
+	// events stored while relinking would miss their indexes
+	app.lock.Lock()
+	defer app.lock.Unlock()
+	return indexer.RebuildIndexes(app.MainLog)
+}
+
+func IndexesRebuildHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+
+	err = runningApp.RebuildIndexes()
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(200, map[string]string{"log": runningApp.MainLog, "action": RebuiltOpt})
+}
+
 // Storing event options
 type StoreOptions struct {
 	LockVersion uint64 `json:"lockversion"`
@@ -0,0 +1,68 @@
+package stores
+
+import (
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+const (
+	CheckpointBucket = "checkpoints"
+)
+
+// View checkpoints stored into BoltDB file
+type BoltCheckpoints struct {
+	Path string `json:"path"`
+	db   *bolt.DB
+}
+
+func NewBoltCheckpoints(path string) (*BoltCheckpoints, error) {
+	var b BoltCheckpoints
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(CheckpointBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	b.Path = path
+	b.db = db
+	return &b, nil
+}
+
+func (b *BoltCheckpoints) Get(view string) (uint64, error) {
+	var version uint64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(CheckpointBucket)).Get([]byte(view))
+		if len(v) == 8 {
+			version = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return version, err
+}
+
+func (b *BoltCheckpoints) Save(view string, version uint64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, version)
+		return tx.Bucket([]byte(CheckpointBucket)).Put([]byte(view), v)
+	})
+}
+
+func (b *BoltCheckpoints) Delete(view string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CheckpointBucket)).Delete([]byte(view))
+	})
+}
+
+func (b *BoltCheckpoints) Close() error {
+	return b.db.Close()
+}
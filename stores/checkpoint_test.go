@@ -0,0 +1,42 @@
+package stores
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoints.db")
+
+	b, err := NewBoltCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := b.Get("items")
+	if err != nil || v != 0 {
+		t.Fatal("expected no checkpoint, got", v, err)
+	}
+	err = b.Save("items", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	// checkpoints survive restarts
+	b, err = NewBoltCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	v, err = b.Get("items")
+	if err != nil || v != 42 {
+		t.Fatal("expected checkpoint 42, got", v, err)
+	}
+}
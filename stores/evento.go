@@ -3,28 +3,48 @@ package stores
 import (
 	es "bitbucket.org/dgub/evento/api"
 	dom "bitbucket.org/dgub/evento/dom"
+	"bitbucket.org/dgub/evento/proxy/use"
+	"context"
+	"errors"
 	"github.com/diegogub/gocqrs"
+	nap "github.com/jmcvetta/napping"
 	"log"
-	"strings"
 )
 
 type EventoStore struct {
-	URL    string `json:"url"`
-	Proxy  bool   `json:"proxy"`
-	client *es.Client
+	URL   string `json:"url"`
+	Proxy bool   `json:"proxy"`
+	// link events to category and event type index streams
+	Index bool `json:"index"`
+	// nsqd address, to notify stream updates instead of polling
+	Nsqd string `json:"nsqd"`
+
+	client   *es.Client
+	notifier *eventoNotifier
+}
+
+// Event linked to index streams
+type indexedEvent struct {
+	gocqrs.Eventer
+	indexes []string
+}
+
+func (ie indexedEvent) GetLinks() []string {
+	return append(ie.Eventer.GetLinks(), ie.indexes...)
 }
 
 func NewEventoStore(url string, proxy bool) *EventoStore {
 	var e EventoStore
 	var cli *es.Client
+	var copt es.ConnectOpt
 
 	if proxy {
-		copt := es.ConnectOpt{
+		copt = es.ConnectOpt{
 			EventoProxy: url,
 		}
 		cli = es.NewClient(copt)
 	} else {
-		copt := es.ConnectOpt{
+		copt = es.ConnectOpt{
 			EventoServer: url,
 		}
 		cli = es.NewClient(copt)
@@ -34,13 +54,20 @@ func NewEventoStore(url string, proxy bool) *EventoStore {
 		}
 	}
 
+	e.URL = url
+	e.Proxy = proxy
 	e.client = cli
+	e.notifier = newEventoNotifier()
 	return &e
 }
 
 func (estore EventoStore) Store(e gocqrs.Eventer, opt gocqrs.StoreOptions) (uint64, error) {
 	var v uint64
 	var err error
+	if estore.Index {
+		e = indexedEvent{e, gocqrs.IndexStreams(e)}
+	}
+
 	// TODO retry
 	if opt.LockVersion > 0 {
 		return estore.client.StoreEvent(e, &es.StoreOpt{Create: opt.Create, Lock: true, ExpectedVersion: opt.LockVersion})
@@ -81,18 +108,97 @@ func (es EventoStore) Version(streamid string) (uint64, error) {
 	return es.client.Version(streamid)
 }
 
+// Notify stream updates received from NSQ, fails if Nsqd is not set
+func (estore EventoStore) Notify(ctx context.Context, streamid string) (<-chan uint64, error) {
+	if estore.notifier == nil {
+		return nil, NoNsqdError
+	}
+	return estore.notifier.listen(ctx, estore.Nsqd, streamid)
+}
+
+// Purge index streams of log events and link them again, as they are
+// linked when stored. Streams out of the log keep their indexes.
+func (estore EventoStore) RebuildIndexes(logStream string) error {
+	version, err := estore.client.Version(logStream)
+	if err != nil {
+		return err
+	}
+
+	links := make([]dom.Event, 0)
+	purged := make(map[string]bool)
+	for e := range estore.client.RangeStream(logStream, 0, version) {
+		var link dom.Event
+		link.Type = e.Type
+		link.Link = true
+		link.LinkStream = e.StreamId
+		link.LinkVersion = e.Version
+		if e.LinkStream != "" {
+			link.LinkStream = e.LinkStream
+			link.LinkVersion = e.LinkVersion
+		}
+		links = append(links, link)
+
+		for _, index := range gocqrs.StreamIndexes(link.LinkStream, link.Type) {
+			if purged[index] {
+				continue
+			}
+			purged[index] = true
+			err = estore.client.PurgeStream(index)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, link := range links {
+		for _, index := range gocqrs.StreamIndexes(link.LinkStream, link.Type) {
+			err = estore.link(index, link)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Store link event into stream, evento client only links new events
+func (estore EventoStore) link(stream string, link dom.Event) error {
+	var s nap.Session
+	host := estore.URL
+	if estore.Proxy {
+		var eh use.EventoHost
+		_, err := s.Get(estore.URL+"/reg/"+stream, nil, &eh, &eh)
+		if err != nil {
+			return err
+		}
+		host = eh.URL
+	}
+
+	response := make(map[string]interface{})
+	res, err := s.Post(host+"/stream/"+stream, link, &response, &response)
+	if err != nil {
+		return err
+	}
+	if res.Status() != 200 {
+		return errors.New("Failed to link event into " + stream)
+	}
+	return nil
+}
+
 func NewEvent(e dom.Event) gocqrs.Event {
 	ev := gocqrs.NewEvent(e.GetId(), e.GetType(), e.GetData())
 	ev.EventStream = e.StreamId
 	ev.EventVersion = e.Version
 
-	var parts []string
+	stream := e.StreamId
+	ev.EntityVersion = e.Version
 	if e.LinkStream != "" {
-		parts = strings.Split(e.LinkStream, "-")
-	} else {
-		parts = strings.Split(e.StreamId, "-")
+		stream = e.LinkStream
+		ev.EntityVersion = e.LinkVersion
 	}
-	ev.Entity = parts[0]
-	ev.EntityID = parts[1]
+
+	// entity is stream category, App.ParseStream resolves entity name
+	ev.AccountID, ev.Group, ev.Entity, ev.EntityID = gocqrs.ParseStream(stream)
 	return *ev
 }
@@ -0,0 +1,202 @@
+package stores
+
+import (
+	"context"
+	"github.com/diegogub/gocqrs"
+	"sync"
+	"time"
+)
+
+// Event stored in memory, link streams keep the original stream
+type memEvent struct {
+	ID        string
+	Type      string
+	Stream    string
+	Version   uint64
+	Timestamp time.Time
+	Data      map[string]interface{}
+}
+
+// In memory event store, for tests and development.
+// Stream versions start at 1.
+type MemStore struct {
+	lock    sync.RWMutex
+	streams map[string][]*memEvent
+	subs    map[string][]chan uint64
+
+	// link events to category and event type index streams
+	Index bool `json:"index"`
+}
+
+func NewMemStore() *MemStore {
+	var m MemStore
+	m.streams = make(map[string][]*memEvent)
+	m.subs = make(map[string][]chan uint64)
+	return &m
+}
+
+func (m *MemStore) Store(e gocqrs.Eventer, opt gocqrs.StoreOptions) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stream := e.GetStream()
+	current := uint64(len(m.streams[stream]))
+	if opt.Create && current > 0 {
+		return 0, gocqrs.FailStoreError
+	}
+
+	if opt.LockVersion > 0 && opt.LockVersion != current {
+		return 0, gocqrs.LockVersionError
+	}
+
+	data := make(map[string]interface{})
+	for k, v := range e.GetData() {
+		data[k] = v
+	}
+	me := &memEvent{
+		ID:        e.GetId(),
+		Type:      e.GetType(),
+		Stream:    stream,
+		Version:   current + 1,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+
+	links := e.GetLinks()
+	if m.Index {
+		links = append(links, gocqrs.IndexStreams(e)...)
+	}
+
+	m.append(stream, me)
+	for _, l := range links {
+		if l != "" && l != stream {
+			m.append(l, me)
+		}
+	}
+
+	return uint64(len(m.streams[stream])), nil
+}
+
+// append event to stream and notify listeners, lock should be held
+func (m *MemStore) append(stream string, me *memEvent) {
+	m.streams[stream] = append(m.streams[stream], me)
+	version := uint64(len(m.streams[stream]))
+	for _, ch := range m.subs[stream] {
+		select {
+		case ch <- version:
+		default:
+			// listener already has pending update
+		}
+	}
+}
+
+func (m *MemStore) Range(streamid string) (chan gocqrs.Eventer, uint64) {
+	m.lock.RLock()
+	events := append([]*memEvent{}, m.streams[streamid]...)
+	m.lock.RUnlock()
+
+	ch := make(chan gocqrs.Eventer, len(events))
+	for _, me := range events {
+		// replayed events have no version, as evento store
+		ch <- m.event(streamid, 0, me)
+	}
+	close(ch)
+	return ch, uint64(len(events))
+}
+
+func (m *MemStore) Version(streamid string) (uint64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	events, ok := m.streams[streamid]
+	if !ok {
+		return 0, gocqrs.StreamNotFoundError
+	}
+	return uint64(len(events)), nil
+}
+
+func (m *MemStore) Scan(streamid string, from, to uint64) chan gocqrs.Event {
+	m.lock.RLock()
+	events := m.streams[streamid]
+	if from < 1 {
+		from = 1
+	}
+	if to > uint64(len(events)) {
+		to = uint64(len(events))
+	}
+
+	selected := make([]*memEvent, 0)
+	if from <= to {
+		selected = append(selected, events[from-1:to]...)
+	}
+	m.lock.RUnlock()
+
+	ch := make(chan gocqrs.Event, len(selected))
+	for i, me := range selected {
+		ch <- *m.event(streamid, from+uint64(i), me)
+	}
+	close(ch)
+	return ch
+}
+
+func (m *MemStore) event(streamid string, version uint64, me *memEvent) *gocqrs.Event {
+	data := make(map[string]interface{})
+	for k, v := range me.Data {
+		data[k] = v
+	}
+
+	ev := gocqrs.NewEvent(me.ID, me.Type, data)
+	ev.EventTimestamp = me.Timestamp
+	ev.EventStream = streamid
+	ev.EventVersion = version
+	ev.EntityVersion = me.Version
+	// entity is stream category, App.ParseStream resolves entity name
+	ev.AccountID, ev.Group, ev.Entity, ev.EntityID = gocqrs.ParseStream(me.Stream)
+	return ev
+}
+
+// Notify new versions of stream until context is done
+func (m *MemStore) Notify(ctx context.Context, streamid string) (<-chan uint64, error) {
+	ch := make(chan uint64, 1)
+
+	m.lock.Lock()
+	m.subs[streamid] = append(m.subs[streamid], ch)
+	m.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		subs := make([]chan uint64, 0)
+		for _, s := range m.subs[streamid] {
+			if s != ch {
+				subs = append(subs, s)
+			}
+		}
+		m.subs[streamid] = subs
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Relink log events into index streams, only index streams of log events
+// are purged. Streams out of the log keep their indexes.
+func (m *MemStore) RebuildIndexes(logStream string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, me := range m.streams[logStream] {
+		for _, index := range gocqrs.StreamIndexes(me.Stream, me.Type) {
+			delete(m.streams, index)
+		}
+	}
+
+	for _, me := range m.streams[logStream] {
+		for _, index := range gocqrs.StreamIndexes(me.Stream, me.Type) {
+			m.append(index, me)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package stores
+
+import (
+	"context"
+	"github.com/diegogub/gocqrs"
+	"testing"
+	"time"
+)
+
+func storeEvent(m *MemStore, id, eventType string, opt gocqrs.StoreOptions) (uint64, error) {
+	ev := gocqrs.NewEvent("", eventType, map[string]interface{}{"id": id})
+	ev.Entity = "items"
+	ev.EntityID = id
+	ev.CorrelationStream = "log"
+	return m.Store(ev, opt)
+}
+
+func TestMemStoreVersions(t *testing.T) {
+	m := NewMemStore()
+
+	_, err := m.Version("items-i1")
+	if err != gocqrs.StreamNotFoundError {
+		t.Fatal("expected stream not found, got", err)
+	}
+
+	v, err := storeEvent(m, "i1", "ItemsCreated", gocqrs.StoreOptions{Create: true})
+	if err != nil || v != 1 {
+		t.Fatal("expected version 1, got", v, err)
+	}
+
+	_, err = storeEvent(m, "i1", "ItemsCreated", gocqrs.StoreOptions{Create: true})
+	if err == nil {
+		t.Fatal("create on existing stream should fail")
+	}
+
+	_, err = storeEvent(m, "i1", "ItemsUpdated", gocqrs.StoreOptions{LockVersion: 2})
+	if err != gocqrs.LockVersionError {
+		t.Fatal("expected lock version error, got", err)
+	}
+
+	v, err = storeEvent(m, "i1", "ItemsUpdated", gocqrs.StoreOptions{LockVersion: 1})
+	if err != nil || v != 2 {
+		t.Fatal("expected version 2, got", v, err)
+	}
+
+	events := m.Scan("items-i1", 2, 2)
+	e := <-events
+	if e.EventVersion != 2 || e.EventType != "ItemsUpdated" || e.EntityID != "i1" {
+		t.Fatal("unexpected scanned event", e)
+	}
+}
+
+func TestMemStoreIndexes(t *testing.T) {
+	m := NewMemStore()
+	m.Index = true
+
+	storeEvent(m, "i1", "ItemsCreated", gocqrs.StoreOptions{})
+	storeEvent(m, "i2", "ItemsCreated", gocqrs.StoreOptions{})
+	storeEvent(m, "i1", "ItemsUpdated", gocqrs.StoreOptions{})
+
+	// out of the log, indexes are kept
+	ref := gocqrs.NewEvent("", gocqrs.ReferenceAddedEvent, map[string]interface{}{})
+	ref.Entity = gocqrs.ReferencesEntity
+	ref.EntityID = "items_i1"
+	m.Store(ref, gocqrs.StoreOptions{})
+
+	check := func() {
+		for s, expected := range map[string]uint64{
+			"log":                                              3,
+			gocqrs.CategoryStream("items"):                     3,
+			gocqrs.EventTypeStream("ItemsCreated"):             2,
+			gocqrs.EventTypeStream("ItemsUpdated"):             1,
+			gocqrs.CategoryStream(gocqrs.ReferencesEntity):     1,
+			gocqrs.EventTypeStream(gocqrs.ReferenceAddedEvent): 1,
+		} {
+			v, _ := m.Version(s)
+			if v != expected {
+				t.Fatal("expected", expected, "events in", s, "got", v)
+			}
+		}
+	}
+	check()
+
+	err := m.RebuildIndexes("log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestMemStoreNotify(t *testing.T) {
+	m := NewMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch, err := m.Notify(ctx, "items-i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storeEvent(m, "i1", "ItemsCreated", gocqrs.StoreOptions{})
+	select {
+	case v := <-ch:
+		if v != 1 {
+			t.Fatal("expected version 1, got", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream update not notified")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("unexpected update")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notify channel not closed")
+	}
+}
@@ -0,0 +1,116 @@
+package stores
+
+import (
+	dom "bitbucket.org/dgub/evento/dom"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/diegogub/lib"
+	"github.com/nsqio/go-nsq"
+	"sync"
+)
+
+// NSQ topic evento publishes stored events to
+const EventoTopic = "evento"
+
+var (
+	NoNsqdError = errors.New("Nsqd address not set, can't notify stream updates")
+)
+
+// Listens evento events through NSQ and notifies stream listeners
+type eventoNotifier struct {
+	lock     sync.Mutex
+	consumer *nsq.Consumer
+	subs     map[string][]chan uint64
+}
+
+func newEventoNotifier() *eventoNotifier {
+	var n eventoNotifier
+	n.subs = make(map[string][]chan uint64)
+	return &n
+}
+
+// Consume evento topic, consumer is stopped if it fails to connect
+func (n *eventoNotifier) start(nsqd string) error {
+	if nsqd == "" {
+		return NoNsqdError
+	}
+	if n.consumer != nil {
+		return nil
+	}
+
+	// every process needs its own channel to receive all events
+	channel := "gocqrs-" + lib.NewShortId("") + "#ephemeral"
+	consumer, err := nsq.NewConsumer(EventoTopic, channel, nsq.NewConfig())
+	if err != nil {
+		return err
+	}
+	consumer.AddHandler(n)
+
+	err = consumer.ConnectToNSQD(nsqd)
+	if err != nil {
+		consumer.Stop()
+		return errors.New("Failed to connect to nsqd: " + err.Error())
+	}
+	n.consumer = consumer
+	return nil
+}
+
+func (n *eventoNotifier) listen(ctx context.Context, nsqd, streamid string) (<-chan uint64, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	err := n.start(nsqd)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan uint64, 1)
+	n.subs[streamid] = append(n.subs[streamid], ch)
+
+	go func() {
+		<-ctx.Done()
+		n.lock.Lock()
+		defer n.lock.Unlock()
+
+		subs := make([]chan uint64, 0)
+		for _, s := range n.subs[streamid] {
+			if s != ch {
+				subs = append(subs, s)
+			}
+		}
+		n.subs[streamid] = subs
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+func (n *eventoNotifier) notify(streamid string, version uint64) {
+	for _, ch := range n.subs[streamid] {
+		select {
+		case ch <- version:
+		default:
+			// listener already has pending update
+		}
+	}
+}
+
+// Stored event published by evento, only used to know streams advanced
+func (n *eventoNotifier) HandleMessage(msg *nsq.Message) error {
+	var e dom.Event
+	err := json.Unmarshal(msg.Body, &e)
+	if err != nil {
+		return err
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.notify(e.StreamId, e.Version)
+	for _, l := range e.LinkStreams {
+		// link version unknown, listeners check store version
+		n.notify(l, 0)
+	}
+	return nil
+}
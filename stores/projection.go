@@ -0,0 +1,353 @@
+package stores
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/diegogub/gocqrs"
+	"math"
+	"time"
+)
+
+const (
+	RecordsBucket     = "records"
+	PositionBucket    = "position"
+	IndexBucketPrefix = "index:"
+)
+
+// Entity projection stored into BoltDB file. Indexed fields are stored
+// ordered, equality and range filters on them only read matching entities.
+// Last applied stream version is stored with records, so restarted views
+// continue from it without a durable checkpoint store.
+type BoltProjection struct {
+	Path      string `json:"path"`
+	Projector gocqrs.Projector
+	stream    string
+	indexes   []string
+	db        *bolt.DB
+}
+
+// Projection of entity conf built from stream, entity category
+// index stream if empty.
+func NewBoltProjection(path string, conf *gocqrs.EntityConf, stream string) (*BoltProjection, error) {
+	var b BoltProjection
+	if stream == "" {
+		stream = gocqrs.CategoryStream(conf.Category())
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+
+	b.Path = path
+	b.Projector = gocqrs.Projector{Conf: conf}
+	b.stream = stream
+	b.db = db
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.bucket(RecordsBucket))
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateBucketIfNotExists(b.bucket(PositionBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &b, nil
+}
+
+// buckets are prefixed with entity name
+func (b *BoltProjection) bucket(name string) []byte {
+	return []byte(b.Projector.Conf.Name + ":" + name)
+}
+
+func (b *BoltProjection) indexBucket(field string) []byte {
+	return b.bucket(IndexBucketPrefix + field)
+}
+
+// Add secondary indexes on entity fields, existing entities are indexed
+func (b *BoltProjection) Index(fields ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(b.bucket(RecordsBucket))
+		for _, f := range fields {
+			if contains(b.indexes, f) {
+				continue
+			}
+
+			tx.DeleteBucket(b.indexBucket(f))
+			index, err := tx.CreateBucket(b.indexBucket(f))
+			if err != nil {
+				return err
+			}
+
+			err = records.ForEach(func(k, v []byte) error {
+				var r gocqrs.Record
+				err := json.Unmarshal(v, &r)
+				if err != nil {
+					return err
+				}
+				return index.Put(indexKey(r.Field(f), r.Key), k)
+			})
+			if err != nil {
+				return err
+			}
+			b.indexes = append(b.indexes, f)
+		}
+		return nil
+	})
+}
+
+func (b *BoltProjection) Init(params map[string]string, dev bool) {}
+
+func (b *BoltProjection) Purge() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		buckets := [][]byte{b.bucket(RecordsBucket), b.bucket(PositionBucket)}
+		for _, f := range b.indexes {
+			buckets = append(buckets, b.indexBucket(f))
+		}
+
+		for _, name := range buckets {
+			err := tx.DeleteBucket(name)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			_, err = tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Last applied version of stream
+func (b *BoltProjection) Status() (uint64, error) {
+	var version uint64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(b.bucket(PositionBucket)).Get([]byte(b.stream))
+		if v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return version, err
+}
+
+func (b *BoltProjection) Stream() string {
+	return b.stream
+}
+
+func (b *BoltProjection) Rebuild() error {
+	return b.Purge()
+}
+
+func (b *BoltProjection) Apply(e gocqrs.Event) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		// events already applied are skipped, checkpoint may be behind
+		position := tx.Bucket(b.bucket(PositionBucket))
+		if v := position.Get([]byte(b.stream)); v != nil && binary.BigEndian.Uint64(v) >= e.EventVersion {
+			return nil
+		}
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, e.EventVersion)
+		err := position.Put([]byte(b.stream), version)
+		if err != nil {
+			return err
+		}
+
+		records := tx.Bucket(b.bucket(RecordsBucket))
+		key := gocqrs.ProjectionKey(e.AccountID, e.Group, b.Projector.Conf.Name, e.EntityID)
+
+		var current *gocqrs.Record
+		if v := records.Get([]byte(key)); v != nil {
+			current = &gocqrs.Record{}
+			err := json.Unmarshal(v, current)
+			if err != nil {
+				return err
+			}
+		}
+
+		r, err := b.Projector.Project(e, current)
+		if err != nil || r == nil {
+			return err
+		}
+
+		for _, f := range b.indexes {
+			index := tx.Bucket(b.indexBucket(f))
+			if current != nil {
+				err = index.Delete(indexKey(current.Field(f), key))
+				if err != nil {
+					return err
+				}
+			}
+			err = index.Put(indexKey(r.Field(f), key), []byte(key))
+			if err != nil {
+				return err
+			}
+		}
+
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return records.Put([]byte(key), v)
+	})
+}
+
+func (b *BoltProjection) Get(accid, group, id string) (*gocqrs.Entity, error) {
+	var r gocqrs.Record
+	err := b.db.View(func(tx *bolt.Tx) error {
+		key := gocqrs.ProjectionKey(accid, group, b.Projector.Conf.Name, id)
+		v := tx.Bucket(b.bucket(RecordsBucket)).Get([]byte(key))
+		if v == nil {
+			return gocqrs.EntityNotFound
+		}
+		return json.Unmarshal(v, &r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Entity, nil
+}
+
+func (b *BoltProjection) Query(q gocqrs.Query) (*gocqrs.QueryResult, error) {
+	candidates := make([]*gocqrs.Record, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		records := tx.Bucket(b.bucket(RecordsBucket))
+		add := func(v []byte) error {
+			var r gocqrs.Record
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			candidates = append(candidates, &r)
+			return nil
+		}
+
+		f, ok := b.indexFilter(q)
+		if !ok {
+			return records.ForEach(func(k, v []byte) error {
+				return add(v)
+			})
+		}
+
+		from, to := indexRange(f)
+		c := tx.Bucket(b.indexBucket(f.Field)).Cursor()
+		for k, key := c.Seek(from); k != nil && bytes.Compare(k, to) < 0; k, key = c.Next() {
+			v := records.Get(key)
+			if v == nil {
+				continue
+			}
+			err := add(v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Run(candidates)
+}
+
+// First filter on indexed field, equality filters are preferred
+func (b *BoltProjection) indexFilter(q gocqrs.Query) (gocqrs.Filter, bool) {
+	var found *gocqrs.Filter
+	for i, f := range q.Filters {
+		if !contains(b.indexes, f.Field) {
+			continue
+		}
+		switch f.Op {
+		case gocqrs.EqOp, "":
+			return f, true
+		case gocqrs.GtOp, gocqrs.GteOp, gocqrs.LtOp, gocqrs.LteOp:
+			if found == nil {
+				found = &q.Filters[i]
+			}
+		}
+	}
+
+	if found == nil {
+		return gocqrs.Filter{}, false
+	}
+	return *found, true
+}
+
+func (b *BoltProjection) Close() error {
+	return b.db.Close()
+}
+
+// Index keys range [from, to) matching filter
+func indexRange(f gocqrs.Filter) (from, to []byte) {
+	v := encodeValue(f.Value)
+	// values of same type
+	typeFrom := v[:1]
+	typeTo := []byte{v[0] + 1}
+
+	equal := append(append([]byte{}, v...), 0)
+	after := append(append([]byte{}, v...), 1)
+
+	switch f.Op {
+	case gocqrs.GtOp:
+		return after, typeTo
+	case gocqrs.GteOp:
+		return equal, typeTo
+	case gocqrs.LtOp:
+		return typeFrom, equal
+	case gocqrs.LteOp:
+		return typeFrom, after
+	}
+	return equal, after
+}
+
+// Index key is encoded field value and entity key
+func indexKey(v interface{}, key string) []byte {
+	k := encodeValue(v)
+	k = append(k, 0)
+	return append(k, []byte(key)...)
+}
+
+// Encode value keeping gocqrs.CompareValues order
+func encodeValue(v interface{}) []byte {
+	rank := byte(gocqrs.ValueRank(v))
+	switch rank {
+	case 0:
+		return []byte{rank}
+	case 1:
+		bits := math.Float64bits(gocqrs.ToFloat(v))
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		b := make([]byte, 9)
+		b[0] = rank
+		binary.BigEndian.PutUint64(b[1:], bits)
+		return b
+	case 2:
+		return append([]byte{rank}, []byte(v.(string))...)
+	case 3:
+		if v.(bool) {
+			return []byte{rank, 1}
+		}
+		return []byte{rank, 0}
+	}
+	j, _ := json.Marshal(v)
+	return append([]byte{rank}, j...)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
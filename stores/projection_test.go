@@ -0,0 +1,62 @@
+package stores
+
+import (
+	"github.com/diegogub/gocqrs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltProjectionPosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "projection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "items.db")
+
+	m := NewMemStore()
+	m.Index = true
+	storeEvent(m, "i1", "ItemsCreated", gocqrs.StoreOptions{})
+	storeEvent(m, "i2", "ItemsCreated", gocqrs.StoreOptions{})
+	storeEvent(m, "i1", "ItemsUpdated", gocqrs.StoreOptions{})
+
+	conf := gocqrs.NewEntityConf("items")
+	conf.AddCRUD(false)
+	apply := func(b *BoltProjection) {
+		for e := range m.Scan(b.Stream(), 1, 3) {
+			err := b.Apply(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	b, err := NewBoltProjection(path, conf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply(b)
+	b.Close()
+
+	// restarted projection knows its position, replays are skipped
+	b, err = NewBoltProjection(path, conf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	status, err := b.Status()
+	if err != nil || status != 3 {
+		t.Fatal("expected position 3, got", status, err)
+	}
+	apply(b)
+
+	entity, err := b.Get("", "", "i1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entity.Version != 2 {
+		t.Fatal("expected entity version 2, got", entity.Version)
+	}
+}
@@ -0,0 +1,301 @@
+package gocqrs
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"gopkg.in/gin-gonic/gin.v1"
+	"log"
+	"strings"
+	"time"
+)
+
+// Unique keys are reserved into reservation streams, one per key value,
+// owned by the entity stream holding the value. Reservation events are
+// linked to ReservationLog.
+const (
+	ReservationEntity = "$unique"
+	ReservationLog    = "$reservations"
+
+	ReservedEvent = "UniqueReserved"
+	ReleasedEvent = "UniqueReleased"
+
+	ReservationOwnerKey  = "owner"
+	ReservationEntityKey = "entity"
+	ReservationTimeKey   = "at"
+
+	// owner event may not be stored yet, reservation can't be taken over
+	PendingReservation = time.Second * 30
+)
+
+var (
+	ReservationConflictError = errors.New("Reservation changed concurrently")
+)
+
+// Entity fields whose values can only be used by one entity of account
+type UniqueKey struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// Values of key into entity, false if any field is not set
+func (uk UniqueKey) values(entity *Entity) ([]interface{}, bool) {
+	if entity == nil || entity.Deleted {
+		return nil, false
+	}
+
+	values := make([]interface{}, 0)
+	for _, f := range uk.Fields {
+		v, ok := entity.Data[f]
+		if !ok || v == nil {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+// Add unique key over fields, composite if more than one field
+func (e *EntityConf) AddUnique(fields ...string) *EntityConf {
+	if len(fields) == 0 {
+		log.Fatal("Invalid unique key for " + e.Name)
+	}
+	e.UniqueKeys = append(e.UniqueKeys, UniqueKey{strings.Join(fields, "+"), fields})
+	return e
+}
+
+// Reservation stream of key value
+func (app *App) reservationStream(econf *EntityConf, accid string, key UniqueKey, values []interface{}) string {
+	b, _ := json.Marshal(values)
+	sum := sha1.Sum(b)
+	id := econf.Name + "_" + key.Name + "_" + hex.EncodeToString(sum[:])
+	return EntityStream(app.tenant(econf, accid), "", ReservationEntity, id)
+}
+
+// Current owner of reservation stream, when it was reserved and stream version
+func (app *App) reservation(stream string) (string, time.Time, uint64) {
+	owner := ""
+	var at time.Time
+	ch, version := app.Store.Range(stream)
+	for e := range ch {
+		switch e.GetType() {
+		case ReservedEvent:
+			owner, _ = e.GetData()[ReservationOwnerKey].(string)
+			// reservations without time are not pending
+			s, _ := e.GetData()[ReservationTimeKey].(string)
+			at, _ = time.Parse(time.RFC3339Nano, s)
+		case ReleasedEvent:
+			owner = ""
+		}
+	}
+	return owner, at, version
+}
+
+// Change owner of reservation stream of entity, fails if it was changed since version
+func (app *App) setReservation(entity, stream, owner string, version uint64) error {
+	accid, _, _, id := ParseStream(stream)
+
+	var ev *Event
+	if owner == "" {
+		ev = NewEvent("", ReleasedEvent, map[string]interface{}{ReservationEntityKey: entity})
+	} else {
+		ev = NewEvent("", ReservedEvent, map[string]interface{}{
+			ReservationOwnerKey:  owner,
+			ReservationEntityKey: entity,
+			ReservationTimeKey:   time.Now().UTC().Format(time.RFC3339Nano),
+		})
+	}
+	ev.AccountID = accid
+	ev.Entity = ReservationEntity
+	ev.EntityID = id
+	ev.CorrelationStream = ReservationLog
+
+	opt := StoreOptions{LockVersion: version}
+	if version == 0 {
+		opt.Create = true
+	}
+	_, err := app.Store.Store(ev, opt)
+	if err != nil {
+		return ReservationConflictError
+	}
+	return nil
+}
+
+// Entity of reservation event, reservations stored without entity
+// are decoded from owner stream.
+func (app *App) reservationEntity(e *Event) string {
+	if entity, ok := e.GetData()[ReservationEntityKey].(string); ok {
+		return entity
+	}
+	if owner, ok := e.GetData()[ReservationOwnerKey].(string); ok && owner != "" {
+		_, _, entity, _ := app.ParseStream(owner)
+		return entity
+	}
+	return ""
+}
+
+// Check owner still holds key value, reservations are not released
+// if the owner failed to store its event. Owner event may also not be
+// stored yet, only reservations older than PendingReservation are checked.
+func (app *App) holds(econf *EntityConf, owner string, key UniqueKey, stream string) bool {
+	accid, group, _, id := app.ParseStream(owner)
+	entity, version, err := app.ScopedEntity(accid, group, econf.Name, id)
+	if err != nil || version == 0 {
+		return false
+	}
+
+	values, ok := key.values(entity)
+	return ok && app.reservationStream(econf, accid, key, values) == stream
+}
+
+// Reserve unique key values of new entity state and return reservations
+// to release once the event is stored, those of prior state not used anymore.
+func (app *App) reserveUnique(econf *EntityConf, accid, owner string, prior, entity *Entity) ([]string, []string, error) {
+	reserved := make([]string, 0)
+	release := make([]string, 0)
+	invalid := make(ValidationErrors, 0)
+
+	for _, key := range econf.UniqueKeys {
+		next := ""
+		if values, ok := key.values(entity); ok {
+			next = app.reservationStream(econf, accid, key, values)
+		}
+		current := ""
+		if values, ok := key.values(prior); ok {
+			current = app.reservationStream(econf, accid, key, values)
+		}
+
+		if current != "" && current != next {
+			release = append(release, current)
+		}
+		if next == "" {
+			continue
+		}
+
+		holder, at, version := app.reservation(next)
+		if holder == owner {
+			continue
+		}
+
+		// stale reservations are taken over, once holder event would have been stored
+		stale := time.Since(at) > PendingReservation && !app.holds(econf, holder, key, next)
+		if holder == "" || stale {
+			err := app.setReservation(econf.Name, next, owner, version)
+			if err == nil {
+				reserved = append(reserved, next)
+				continue
+			}
+		}
+		invalid = append(invalid, ValidationError{Field: key.Name, Rule: "unique", Message: "already exists"})
+	}
+
+	if len(invalid) > 0 {
+		app.releaseUnique(econf, reserved, owner)
+		return nil, nil, invalid
+	}
+	return reserved, release, nil
+}
+
+// Release entity reservations still owned by owner
+func (app *App) releaseUnique(econf *EntityConf, streams []string, owner string) {
+	for _, s := range streams {
+		holder, _, version := app.reservation(s)
+		if holder != owner {
+			continue
+		}
+		err := app.setReservation(econf.Name, s, "", version)
+		if err != nil {
+			log.Println("Failed to release reservation", s, ":", err)
+		}
+	}
+}
+
+// Rebuild entity reservations from entity states of app log,
+// stale reservations are released. On duplicated values first
+// entity found keeps the reservation.
+func (app *App) RebuildReservations(entity string) error {
+	app.lock.Lock()
+	defer app.lock.Unlock()
+
+	econf, ok := app.Entities[entity]
+	if !ok {
+		return InvalidEntityError
+	}
+
+	// entity streams, by first event order
+	streams := make([]string, 0)
+	seen := make(map[string]bool)
+	for e := range app.scan(app.MainLog) {
+		if e.Entity != econf.Category() {
+			continue
+		}
+		stream := app.stream(econf, e.AccountID, e.Group, e.EntityID)
+		if !seen[stream] {
+			seen[stream] = true
+			streams = append(streams, stream)
+		}
+	}
+
+	desired := make(map[string]string)
+	for _, stream := range streams {
+		accid, group, _, id := app.ParseStream(stream)
+		state, _, err := app.ScopedEntity(accid, group, entity, id)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range econf.UniqueKeys {
+			values, ok := key.values(state)
+			if !ok {
+				continue
+			}
+			rs := app.reservationStream(econf, accid, key, values)
+			if owner, dup := desired[rs]; dup {
+				log.Println("Duplicated unique", key.Name, "for", owner, "and", stream)
+				continue
+			}
+			desired[rs] = stream
+		}
+	}
+
+	// existing reservations of entity
+	reservations := make(map[string]bool)
+	for e := range app.scan(ReservationLog) {
+		if app.reservationEntity(&e) == entity {
+			reservations[EntityStream(e.AccountID, "", ReservationEntity, e.EntityID)] = true
+		}
+	}
+	for rs, _ := range desired {
+		reservations[rs] = true
+	}
+
+	for rs, _ := range reservations {
+		owner, _, version := app.reservation(rs)
+		if owner == desired[rs] {
+			continue
+		}
+		err := app.setReservation(entity, rs, desired[rs], version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild entity unique reservations, admin only
+func UniqueRebuildHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+
+	entity := c.Param("entity")
+	err = runningApp.RebuildReservations(entity)
+	if err != nil {
+		c.JSON(400, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(200, map[string]string{"entity": entity, "action": RebuiltOpt})
+}
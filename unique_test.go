@@ -0,0 +1,87 @@
+package gocqrs_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/diegogub/gocqrs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebuildReservations(t *testing.T) {
+	app, _ := newTestApp()
+	// entity names sharing prefix
+	for _, name := range []string{"member", "member_profile"} {
+		conf := gocqrs.NewEntityConf(name)
+		conf.AddCRUD(false)
+		conf.SetBaseStruct(item{})
+		conf.AddUnique("name")
+		app.RegisterEntity(conf)
+	}
+
+	create := func(entity, id string) error {
+		ev := gocqrs.NewEvent("", strings.Title(entity)+"Created", map[string]interface{}{"name": "pen"})
+		ev.Entity = entity
+		ev.EntityID = id
+		_, _, err := app.HandleEvent(entity, id, "acc", "tester", "", ev, 0)
+		return err
+	}
+
+	for _, entity := range []string{"member", "member_profile"} {
+		if err := create(entity, "e1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := create(entity, "e2"); err == nil {
+			t.Fatal("duplicated value should fail for", entity)
+		}
+	}
+
+	err := app.RebuildReservations("member")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other entities reservations are kept
+	for _, entity := range []string{"member", "member_profile"} {
+		if err := create(entity, "e3"); err == nil {
+			t.Fatal("duplicated value after rebuild should fail for", entity)
+		}
+	}
+}
+
+func TestPendingReservation(t *testing.T) {
+	app, store := newTestApp()
+	app.Entities["items"].AddUnique("name")
+
+	// reservation of other process, whose event is not stored yet
+	reserve := func(value string, at time.Time) {
+		b, _ := json.Marshal([]interface{}{value})
+		sum := sha1.Sum(b)
+		ev := gocqrs.NewEvent("", gocqrs.ReservedEvent, map[string]interface{}{
+			gocqrs.ReservationOwnerKey:  "acc.items-other",
+			gocqrs.ReservationEntityKey: "items",
+			gocqrs.ReservationTimeKey:   at.Format(time.RFC3339Nano),
+		})
+		ev.AccountID = "acc"
+		ev.Entity = gocqrs.ReservationEntity
+		ev.EntityID = "items_name_" + hex.EncodeToString(sum[:])
+		_, err := store.Store(ev, gocqrs.StoreOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reserve("pen", time.Now().UTC())
+	_, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen"})
+	if _, ok := err.(gocqrs.ValidationErrors); !ok {
+		t.Fatal("pending reservation should not be taken over, got", err)
+	}
+
+	reserve("cup", time.Now().UTC().Add(-gocqrs.PendingReservation*2))
+	_, err = handle(app, "acc", "ItemsCreated", "i2", map[string]interface{}{"name": "cup"})
+	if err != nil {
+		t.Fatal("stale reservation should be taken over:", err)
+	}
+}
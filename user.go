@@ -11,6 +11,10 @@ const (
 	UserEntity = "users"
 )
 
+var (
+	InvalidUserError = errors.New("Invalid user")
+)
+
 type User struct {
 	Username string    `json:"username"`
 	Password string    `json:"password"`
@@ -98,7 +102,7 @@ func (u *User) Valid() error {
 		return errors.New("Invalid username")
 	}
 
-	_, ok := runningApp.Roles[u.Role]
+	_, ok := runningApp.GetRole(u.Role)
 	if !ok {
 		return errors.New("Invalid role")
 	}
@@ -131,3 +135,26 @@ func (u *User) GenerateToken() {
 	t := lib.NewLongId("t") + lib.NewLongId("d") + lib.NewLongId("4")
 	u.Token = t
 }
+
+// User of account, users without account are global and only
+// super admins among them are found from other accounts.
+func (app *App) AccountUser(accid, username string) (*Entity, error) {
+	e, version, err := app.AccountEntity(accid, UserEntity, username)
+	if err == nil && version > 0 && !e.Deleted {
+		return e, nil
+	}
+	if accid == "" || app.SuperAdmin == "" {
+		return nil, InvalidUserError
+	}
+
+	var u User
+	e, version, err = app.AccountEntity("", UserEntity, username)
+	if err != nil || version == 0 || e.Deleted {
+		return nil, InvalidUserError
+	}
+	e.Decode(&u)
+	if u.Role != app.SuperAdmin {
+		return nil, InvalidUserError
+	}
+	return e, nil
+}
@@ -1,10 +1,16 @@
 package gocqrs
 
 import (
-	"errors"
 	"github.com/diegogub/lib"
 	"gopkg.in/asaskevich/govalidator.v4"
 	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Validator interface {
@@ -12,6 +18,36 @@ type Validator interface {
 	Validate(e Entity) error
 }
 
+// Failed validation rule of a field, nested fields are addressed
+// with dotted paths and array indexes: address.zip, items[0].name
+type ValidationError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (ve ValidationError) Error() string {
+	if ve.Field == "" {
+		return ve.Message
+	}
+	return ve.Field + " " + ve.Message
+}
+
+// Every failed rule, returned as 422 by HTTP handlers
+type ValidationErrors []ValidationError
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, 0)
+	for _, e := range ve {
+		msg := e.Message
+		if e.Field != "" {
+			msg = e.Field + ": " + msg
+		}
+		msgs = append(msgs, msg)
+	}
+	return "Failed validation: " + strings.Join(msgs, ", ")
+}
+
 type SimpleValidator struct {
 	validators []*EntityProperty
 }
@@ -20,17 +56,17 @@ func (sv SimpleValidator) GetName() string {
 	return "simple-validator"
 }
 
+// Validate every property, returns ValidationErrors with first failed
+// rule of every invalid property
 func (sv SimpleValidator) Validate(e Entity) error {
-	var err error
+	errs := make(ValidationErrors, 0)
 	for _, v := range sv.validators {
-		for _, ep := range v.Validations {
-			err = ep(e.Data[v.Name])
-			if err != nil {
-				return err
-			}
-		}
+		errs = append(errs, v.Check(v.Name, e.Data[v.Name])...)
+	}
+	if len(errs) > 0 {
+		return errs
 	}
-	return err
+	return nil
 }
 
 func NewSimpleValidator(ep ...*EntityProperty) *SimpleValidator {
@@ -44,9 +80,12 @@ func NewSimpleValidator(ep ...*EntityProperty) *SimpleValidator {
 	return &sv
 }
 
+// Entity field validations, functions can return ValidationError with
+// failed rule or ValidationErrors with fields relative to property value.
 type EntityProperty struct {
 	Name        string
 	Validations []func(interface{}) error
+	optional    bool
 }
 
 func NewProperty(name string) *EntityProperty {
@@ -65,7 +104,7 @@ func (ep *EntityProperty) String() *EntityProperty {
 		switch i.(type) {
 		case string:
 		default:
-			return errors.New(ep.Name + " invalid string")
+			return ValidationError{Rule: "string", Message: "invalid string"}
 		}
 		return nil
 	}
@@ -80,10 +119,10 @@ func (ep *EntityProperty) ID() *EntityProperty {
 		case string:
 			err := lib.ValidID(i.(string), true)
 			if err != nil {
-				return errors.New(ep.Name + " invalid id")
+				return ValidationError{Rule: "id", Message: "invalid id"}
 			}
 		default:
-			return errors.New(ep.Name + " invalid id")
+			return ValidationError{Rule: "id", Message: "invalid id"}
 		}
 		return nil
 	}
@@ -98,10 +137,10 @@ func (ep *EntityProperty) Email() *EntityProperty {
 		case string:
 			isEmail := govalidator.IsEmail(i.(string))
 			if !isEmail {
-				return errors.New(ep.Name + " invalid email")
+				return ValidationError{Rule: "email", Message: "invalid email"}
 			}
 		default:
-			return errors.New(ep.Name + " invalid email")
+			return ValidationError{Rule: "email", Message: "invalid email"}
 		}
 		return nil
 	}
@@ -116,10 +155,10 @@ func (ep *EntityProperty) IP() *EntityProperty {
 		case string:
 			isIP := govalidator.IsIP(i.(string))
 			if !isIP {
-				return errors.New(ep.Name + " invalid IP")
+				return ValidationError{Rule: "ip", Message: "invalid IP"}
 			}
 		default:
-			return errors.New(ep.Name + " invalid IP")
+			return ValidationError{Rule: "ip", Message: "invalid IP"}
 		}
 		return nil
 	}
@@ -134,10 +173,10 @@ func (ep *EntityProperty) URL() *EntityProperty {
 		case string:
 			isURL := govalidator.IsURL(i.(string))
 			if !isURL {
-				return errors.New(ep.Name + " invalid url")
+				return ValidationError{Rule: "url", Message: "invalid url"}
 			}
 		default:
-			return errors.New(ep.Name + " invalid url")
+			return ValidationError{Rule: "url", Message: "invalid url"}
 		}
 		return nil
 	}
@@ -151,11 +190,274 @@ func (ep *EntityProperty) NotNull() *EntityProperty {
 		switch v := i.(type) {
 		case string, []byte:
 			if len(v.(string)) == 0 {
-				return errors.New(ep.Name + " invalid value")
+				return ValidationError{Rule: "notNull", Message: "invalid value"}
 			}
 
 		default:
-			return errors.New(ep.Name + " invalid string")
+			return ValidationError{Rule: "notNull", Message: "invalid string"}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// Skip property validations if value is not set
+func (ep *EntityProperty) Optional() *EntityProperty {
+	ep.optional = true
+	return ep
+}
+
+// Validate property value, path is the field of value.
+// Only the first failed rule is returned, nested properties
+// return every failed field.
+func (ep *EntityProperty) Check(path string, i interface{}) ValidationErrors {
+	if i == nil && ep.optional {
+		return nil
+	}
+
+	for _, valid := range ep.Validations {
+		err := valid(i)
+		switch e := err.(type) {
+		case nil:
+			continue
+		case ValidationErrors:
+			errs := make(ValidationErrors, 0)
+			for _, fe := range e {
+				fe.Field = subPath(path, fe.Field)
+				errs = append(errs, fe)
+			}
+			return errs
+		case ValidationError:
+			e.Field = path
+			return ValidationErrors{e}
+		default:
+			return ValidationErrors{{Field: path, Rule: "custom", Message: err.Error()}}
+		}
+	}
+	return nil
+}
+
+// Join field path with relative path of nested value
+func subPath(path, sub string) string {
+	switch {
+	case sub == "":
+		return path
+	case strings.HasPrefix(sub, "["):
+		return path + sub
+	}
+	return fieldPath(path, sub)
+}
+
+func (ep *EntityProperty) Min(min float64) *EntityProperty {
+	valid := func(i interface{}) error {
+		if ValueRank(i) != 1 || ToFloat(i) < min {
+			return ValidationError{Rule: "min", Message: "should be >= " + strconv.FormatFloat(min, 'f', -1, 64)}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) Max(max float64) *EntityProperty {
+	valid := func(i interface{}) error {
+		if ValueRank(i) != 1 || ToFloat(i) > max {
+			return ValidationError{Rule: "max", Message: "should be <= " + strconv.FormatFloat(max, 'f', -1, 64)}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) Int() *EntityProperty {
+	valid := func(i interface{}) error {
+		f := ToFloat(i)
+		if ValueRank(i) != 1 || f != math.Trunc(f) {
+			return ValidationError{Rule: "int", Message: "invalid integer"}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) Bool() *EntityProperty {
+	valid := func(i interface{}) error {
+		switch i.(type) {
+		case bool:
+		default:
+			return ValidationError{Rule: "bool", Message: "invalid boolean"}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// Length of strings, in characters, or arrays. Max 0 is unbounded
+func (ep *EntityProperty) Length(min, max int) *EntityProperty {
+	valid := func(i interface{}) error {
+		var l int
+		switch v := i.(type) {
+		case string:
+			l = utf8.RuneCountInString(v)
+		default:
+			rv := reflect.ValueOf(i)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return ValidationError{Rule: "length", Message: "invalid string or array"}
+			}
+			l = rv.Len()
+		}
+
+		if l < min || (max > 0 && l > max) {
+			msg := "length should be at least " + strconv.Itoa(min)
+			if max > 0 {
+				msg = "length should be between " + strconv.Itoa(min) + " and " + strconv.Itoa(max)
+			}
+			return ValidationError{Rule: "length", Message: msg}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) Regex(pattern string) *EntityProperty {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Fatal("invalid property regex:", pattern)
+	}
+
+	valid := func(i interface{}) error {
+		s, ok := i.(string)
+		if !ok || !re.MatchString(s) {
+			return ValidationError{Rule: "regex", Message: "should match " + pattern}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) Enum(values ...interface{}) *EntityProperty {
+	valid := func(i interface{}) error {
+		for _, v := range values {
+			if CompareValues(i, v) == 0 {
+				return nil
+			}
+		}
+		return ValidationError{Rule: "enum", Message: "should be one of allowed values"}
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// Date as YYYY-MM-DD
+func (ep *EntityProperty) Date() *EntityProperty {
+	return ep.timeLayout("date", "2006-01-02")
+}
+
+// Timestamp as RFC3339, time values are also valid
+func (ep *EntityProperty) Time() *EntityProperty {
+	return ep.timeLayout("time", time.RFC3339)
+}
+
+func (ep *EntityProperty) timeLayout(rule, layout string) *EntityProperty {
+	valid := func(i interface{}) error {
+		switch v := i.(type) {
+		case time.Time:
+			return nil
+		case string:
+			_, err := time.Parse(layout, v)
+			if err == nil {
+				return nil
+			}
+		}
+		return ValidationError{Rule: rule, Message: "invalid " + rule + ", format " + layout}
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// Array with every item valid with item property
+func (ep *EntityProperty) Array(item *EntityProperty) *EntityProperty {
+	valid := func(i interface{}) error {
+		rv := reflect.ValueOf(i)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return ValidationError{Rule: "array", Message: "invalid array"}
+		}
+
+		errs := make(ValidationErrors, 0)
+		for n := 0; n < rv.Len(); n++ {
+			errs = append(errs, item.Check("["+strconv.Itoa(n)+"]", rv.Index(n).Interface())...)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// Object with fields valid with properties
+func (ep *EntityProperty) Nested(props ...*EntityProperty) *EntityProperty {
+	valid := func(i interface{}) error {
+		m, ok := i.(map[string]interface{})
+		if !ok {
+			return ValidationError{Rule: "nested", Message: "invalid object"}
+		}
+
+		errs := make(ValidationErrors, 0)
+		for _, p := range props {
+			errs = append(errs, p.Check(p.Name, m[p.Name])...)
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+func (ep *EntityProperty) UUID() *EntityProperty {
+	valid := func(i interface{}) error {
+		s, ok := i.(string)
+		if !ok || !govalidator.IsUUID(s) {
+			return ValidationError{Rule: "uuid", Message: "invalid uuid"}
+		}
+		return nil
+	}
+
+	ep.Validations = append(ep.Validations, valid)
+	return ep
+}
+
+// E.164 phone number, spaces, dashes, dots and parentheses are ignored
+var phoneRegex = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
+func (ep *EntityProperty) Phone() *EntityProperty {
+	valid := func(i interface{}) error {
+		s, ok := i.(string)
+		if ok {
+			s = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(s)
+		}
+		if !ok || !phoneRegex.MatchString(s) {
+			return ValidationError{Rule: "phone", Message: "invalid phone"}
 		}
 		return nil
 	}
@@ -0,0 +1,126 @@
+package gocqrs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/diegogub/gocqrs"
+	"testing"
+	"time"
+)
+
+func TestPropertyCheck(t *testing.T) {
+	custom := gocqrs.NewProperty("custom")
+	custom.Validations = append(custom.Validations, func(i interface{}) error { return errors.New("bad value") })
+
+	for _, c := range []struct {
+		name  string
+		prop  *gocqrs.EntityProperty
+		value interface{}
+		// failed field:rule
+		want []string
+	}{
+		{"min", gocqrs.NewProperty("n").Min(1), 2.0, nil},
+		{"min equal", gocqrs.NewProperty("n").Min(1), 1.0, nil},
+		{"min lower", gocqrs.NewProperty("n").Min(1), 0.5, []string{"n:min"}},
+		{"min string", gocqrs.NewProperty("n").Min(1), "3", []string{"n:min"}},
+		{"max", gocqrs.NewProperty("n").Max(10), 10.0, nil},
+		{"max greater", gocqrs.NewProperty("n").Max(10), 11.0, []string{"n:max"}},
+		{"int", gocqrs.NewProperty("n").Int(), 2.0, nil},
+		{"int fraction", gocqrs.NewProperty("n").Int(), 2.5, []string{"n:int"}},
+		{"int string", gocqrs.NewProperty("n").Int(), "2", []string{"n:int"}},
+		{"first failed rule", gocqrs.NewProperty("n").Int().Min(5), 2.5, []string{"n:int"}},
+		{"length", gocqrs.NewProperty("s").Length(2, 4), "ab", nil},
+		{"length runes", gocqrs.NewProperty("s").Length(2, 4), "ábcd", nil},
+		{"length short", gocqrs.NewProperty("s").Length(2, 4), "a", []string{"s:length"}},
+		{"length long", gocqrs.NewProperty("s").Length(2, 4), "abcde", []string{"s:length"}},
+		{"length unbounded", gocqrs.NewProperty("s").Length(1, 0), "abcdefgh", nil},
+		{"length array", gocqrs.NewProperty("s").Length(0, 2), []interface{}{1.0, 2.0, 3.0}, []string{"s:length"}},
+		{"length number", gocqrs.NewProperty("s").Length(0, 2), 5.0, []string{"s:length"}},
+		{"regex", gocqrs.NewProperty("s").Regex(`^[a-z]+$`), "abc", nil},
+		{"regex mismatch", gocqrs.NewProperty("s").Regex(`^[a-z]+$`), "Abc", []string{"s:regex"}},
+		{"regex number", gocqrs.NewProperty("s").Regex(`^[0-9]+$`), 5.0, []string{"s:regex"}},
+		{"enum", gocqrs.NewProperty("e").Enum("a", "b"), "b", nil},
+		{"enum number", gocqrs.NewProperty("e").Enum("a", 1.0), 1.0, nil},
+		{"enum missing", gocqrs.NewProperty("e").Enum("a", "b"), "c", []string{"e:enum"}},
+		{"date", gocqrs.NewProperty("d").Date(), "2020-01-31", nil},
+		{"date invalid", gocqrs.NewProperty("d").Date(), "2020-13-01", []string{"d:date"}},
+		{"date with time", gocqrs.NewProperty("d").Date(), "2020-01-31T10:00:00Z", []string{"d:date"}},
+		{"time", gocqrs.NewProperty("t").Time(), "2020-01-31T10:00:00Z", nil},
+		{"time value", gocqrs.NewProperty("t").Time(), time.Now(), nil},
+		{"time date", gocqrs.NewProperty("t").Time(), "2020-01-31", []string{"t:time"}},
+		{"uuid", gocqrs.NewProperty("u").UUID(), "6ba7b810-9dad-11d1-80b4-00c04fd430c8", nil},
+		{"uuid invalid", gocqrs.NewProperty("u").UUID(), "6ba7b810", []string{"u:uuid"}},
+		{"phone", gocqrs.NewProperty("p").Phone(), "+1 (555) 123-4567", nil},
+		{"phone short", gocqrs.NewProperty("p").Phone(), "12345", []string{"p:phone"}},
+		{"phone leading zero", gocqrs.NewProperty("p").Phone(), "+0123456789", []string{"p:phone"}},
+		{"optional unset", gocqrs.NewProperty("o").Optional().String(), nil, nil},
+		{"optional set", gocqrs.NewProperty("o").Optional().String(), 1.0, []string{"o:string"}},
+		{"required unset", gocqrs.NewProperty("o").String(), nil, []string{"o:string"}},
+		{"custom", custom, "x", []string{"custom:custom"}},
+		{"array", gocqrs.NewProperty("tags").Array(gocqrs.NewProperty("tag").Int()), []interface{}{1.0, 2.5, "x"}, []string{"tags[1]:int", "tags[2]:int"}},
+		{"array invalid", gocqrs.NewProperty("tags").Array(gocqrs.NewProperty("tag").Int()), "x", []string{"tags:array"}},
+		{"nested",
+			gocqrs.NewProperty("address").Nested(gocqrs.NewProperty("zip").Length(5, 5), gocqrs.NewProperty("city").String()),
+			map[string]interface{}{"zip": "123"},
+			[]string{"address.zip:length", "address.city:string"}},
+		{"nested optional",
+			gocqrs.NewProperty("address").Nested(gocqrs.NewProperty("zip").Optional().Length(5, 5)),
+			map[string]interface{}{},
+			nil},
+		{"nested invalid", gocqrs.NewProperty("address").Nested(gocqrs.NewProperty("zip").String()), "x", []string{"address:nested"}},
+		{"array of nested",
+			gocqrs.NewProperty("items").Array(gocqrs.NewProperty("item").Nested(gocqrs.NewProperty("name").Length(1, 0))),
+			[]interface{}{map[string]interface{}{"name": "pen"}, map[string]interface{}{"name": ""}},
+			[]string{"items[1].name:length"}},
+		{"nested array",
+			gocqrs.NewProperty("order").Nested(gocqrs.NewProperty("lines").Array(gocqrs.NewProperty("line").Min(1))),
+			map[string]interface{}{"lines": []interface{}{0.0, 2.0}},
+			[]string{"order.lines[0]:min"}},
+	} {
+		errs := c.prop.Check(c.prop.Name, c.value)
+		got := make([]string, 0)
+		for _, e := range errs {
+			got = append(got, e.Field+":"+e.Rule)
+		}
+		if len(got) != len(c.want) {
+			t.Fatal(c.name, "expected", c.want, "got", got)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatal(c.name, "expected", c.want, "got", got)
+			}
+		}
+	}
+}
+
+func TestValidationErrorsResponse(t *testing.T) {
+	app, _ := newTestApp()
+	app.FirstRun = true
+	app.Entities["items"].AddValidator(gocqrs.NewSimpleValidator(
+		gocqrs.NewProperty("name").Length(3, 0),
+		gocqrs.NewProperty("price").Min(0),
+	))
+	app.Routes()
+
+	w := serveJSON(app, "POST", "/event/items", `{"name":"pe","price":-1}`, map[string]string{
+		gocqrs.EventTypeHeader: "ItemsCreated",
+		gocqrs.EntityHeader:    "i1",
+		gocqrs.UserHeader:      "tester",
+		gocqrs.AccountHeader:   "acc",
+	})
+	if w.Code != 422 {
+		t.Fatal("expected 422, got", w.Code, w.Body.String())
+	}
+
+	var res struct {
+		Errors gocqrs.ValidationErrors `json:"errors"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) != 2 || res.Errors[0].Field != "name" || res.Errors[0].Rule != "length" ||
+		res.Errors[1].Field != "price" || res.Errors[1].Rule != "min" || res.Errors[1].Message == "" {
+		t.Fatal("unexpected validation errors", w.Body.String())
+	}
+}
@@ -1,7 +1,11 @@
 package gocqrs
 
 import (
+	"context"
+	"errors"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -10,16 +14,60 @@ const (
 	PurgeOpt   = "purge"
 )
 
+// What to do with an event that keeps failing after retries
+const (
+	// log and continue with next event
+	SkipMode = "skip"
+	// store event into dead-letter stream and continue
+	ParkMode = "park"
+	// stop view, Run returns the error
+	StopMode = "stop"
+)
+
+// Dead-letter events are of type ParkedEvent, so views following event
+// type index streams don't read them, and carry original data plus failure keys
+const (
+	DeadLetterEntity     = "$dl"
+	ParkedEvent          = "Parked"
+	DeadLetterTypeKey    = "$type"
+	DeadLetterOriginKey  = "$stream"
+	DeadLetterErrorKey   = "$error"
+	DeadLetterStreamKey  = "$from"
+	DeadLetterVersionKey = "$version"
+)
+
+var (
+	WaitTimeoutError = errors.New("Timeout waiting for view version")
+)
+
+type RetryPolicy struct {
+	Retries int `json:"retries"`
+	// first wait, doubled on every retry until MaxBackoff
+	Backoff    time.Duration `json:"backoff"`
+	MaxBackoff time.Duration `json:"maxBackoff"`
+	Mode       string        `json:"mode"`
+}
+
 type View struct {
 	Name string `json:"view"`
 
 	All     bool `json:"all"`
 	CatchUp bool `json:"catchup"`
 
-	V       Viewer
-	Store   EventStore
+	V     Viewer
+	Store EventStore
+
+	// view position, owned by runner
+	Checkpoints CheckpointStore `json:"-"`
+	Retry       RetryPolicy     `json:"retry"`
+
 	wakeUP  chan bool
-	running bool
+	errLock sync.Mutex
+	lastErr error
+
+	// closed and replaced every time checkpoint moves
+	progressLock sync.Mutex
+	progress     chan bool
 
 	every time.Duration
 }
@@ -33,31 +81,72 @@ type Viewer interface {
 	Apply(event Event) error
 }
 
+// Viewer building into versioned targets (tables, buckets, indexes..),
+// it can be rebuilt into a shadow target while the live one keeps serving.
+type VersionedViewer interface {
+	Viewer
+	// New viewer instance building into target, not serving queries yet
+	Shadow(target string) (VersionedViewer, error)
+	// Make viewer target the live one, replacing the current target atomically
+	Promote() error
+}
+
 func NewView(name string, i Viewer) *View {
 	var v View
 	v.Name = name
 	v.wakeUP = make(chan bool, 2)
 	v.V = i
+	v.Checkpoints = NewMemCheckpoints()
+	v.Retry = RetryPolicy{
+		Retries:    3,
+		Backoff:    time.Duration(time.Millisecond * 100),
+		MaxBackoff: time.Duration(time.Second * 5),
+		Mode:       StopMode,
+	}
 	// set default wakeup time every 400ms
 	v.every = time.Duration(time.Millisecond * 400)
 	return &v
 }
 
-func (v *View) awake() {
+// polling time when store notifies stream updates, just as fallback
+const notifiedPollEvery = time.Duration(time.Second * 5)
+
+// Stream where failed events are parked
+func (v *View) DeadLetterStream() string {
+	return EntityStream("", "", DeadLetterEntity, v.Name)
+}
+
+func (v *View) awake(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
 	for {
-		time.Sleep(v.every)
-		v.wakeUP <- true
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			select {
+			case v.wakeUP <- true:
+			default:
+			}
+		}
 	}
 }
 
 func (v *View) Run(action string, params map[string]string, dev bool) error {
-	var err error
+	return v.RunContext(context.Background(), action, params, dev)
+}
 
+// Run view until context is done, returns nil on shutdown
+func (v *View) RunContext(ctx context.Context, action string, params map[string]string, dev bool) error {
 	switch action {
 	case RebuiltOpt:
 		err := v.V.Purge()
 		if err != nil {
-			panic("Failed to purge view:" + err.Error())
+			return errors.New("Failed to purge view:" + err.Error())
+		}
+		err = v.Checkpoints.Save(v.Name, 0)
+		if err != nil {
+			return err
 		}
 		// Rebuild view
 		// init view
@@ -67,56 +156,288 @@ func (v *View) Run(action string, params map[string]string, dev bool) error {
 		log.Println("Purging view...")
 		err := v.V.Purge()
 		if err != nil {
-			panic("Failed to purge view:" + err.Error())
+			return errors.New("Failed to purge view:" + err.Error())
 		}
-		return nil
+		return v.Checkpoints.Save(v.Name, 0)
 	default:
 		// init view
 		v.V.Init(params, dev)
 	}
 
-	go v.awake()
+	next, err := v.start()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	mainStream := v.V.Stream()
-	for {
-		v.running = true
-		curVersion, err := v.V.Status()
+
+	// wait for store notifications if possible, polling otherwise
+	var notify <-chan uint64
+	every := v.every
+	if n, ok := v.Store.(Notifier); ok {
+		notify, err = n.Notify(ctx, mainStream)
 		if err != nil {
-			log.Println("View not started, no status available")
+			log.Println("Failed to listen stream updates, polling:", err)
+		} else {
+			every = notifiedPollEvery
 		}
+	}
+	go v.awake(ctx, every)
+
+	for {
 		esVersion, err := v.Store.Version(mainStream)
 		if err != nil {
 			log.Println("Failed to get current stream version, sleeping for few seconds")
-			time.Sleep(time.Second * 5)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second * 5):
+			}
 			continue
 		}
 
-		if esVersion > curVersion || curVersion == 0 {
-			log.Println("current Version:", curVersion, " evento version:", esVersion)
-			log.Println(mainStream)
-			log.Println("Catching up from version ", curVersion, " to version ", esVersion)
-			var events chan Event
-			if curVersion == 0 {
-				events = v.Store.Scan(mainStream, curVersion, esVersion)
-			} else {
-				events = v.Store.Scan(mainStream, curVersion+1, esVersion)
+		if esVersion >= next {
+			log.Println("Catching up from version ", next, " to version ", esVersion)
+			next, err = v.catchUp(ctx, mainStream, next, esVersion)
+			if err != nil {
+				return err
 			}
+		}
 
-			for e := range events {
-				err := v.V.Apply(e)
-				log.Println("Applying..", e.EventVersion)
-				log.Println(e)
-				if err != nil {
-					log.Println(err)
-					log.Fatal("Failed to apply event :", e)
-				}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-v.wakeUP:
+		case _, ok := <-notify:
+			if !ok {
+				notify = nil
 			}
 		}
-		v.running = false
+	}
+}
+
+// Apply stream events from next to head, returns next version to apply.
+// Stops without error if context is done.
+func (v *View) catchUp(ctx context.Context, stream string, next, head uint64) (uint64, error) {
+	events := v.Store.Scan(stream, next, head)
+	defer drain(events)
+
+	for e := range events {
+		if ctx.Err() != nil {
+			return next, nil
+		}
+
+		err := v.apply(ctx, e)
+		if err != nil {
+			if ctx.Err() != nil {
+				return next, nil
+			}
+			return next, err
+		}
+
+		next = e.EventVersion + 1
+		err = v.Checkpoints.Save(v.Name, next)
+		if err != nil {
+			return next, err
+		}
+		v.advanced()
+	}
+	return next, nil
+}
+
+// Wake up WaitFor callers
+func (v *View) advanced() {
+	v.progressLock.Lock()
+	defer v.progressLock.Unlock()
+	if v.progress != nil {
+		close(v.progress)
+		v.progress = nil
+	}
+}
+
+func (v *View) progressed() <-chan bool {
+	v.progressLock.Lock()
+	defer v.progressLock.Unlock()
+	if v.progress == nil {
+		v.progress = make(chan bool)
+	}
+	return v.progress
+}
+
+// Block until view applied version of stream, returns WaitTimeoutError
+// if context is done before.
+func (v *View) WaitFor(ctx context.Context, stream string, version uint64) error {
+	if stream != v.V.Stream() {
+		return errors.New("View " + v.Name + " does not follow stream " + stream)
+	}
+	// no version to wait for
+	if version == 0 {
+		return nil
+	}
+
+	// checkpoints can be moved by other processes
+	ticker := time.NewTicker(v.every)
+	defer ticker.Stop()
+
+	for {
+		progress := v.progressed()
+		next, err := v.Checkpoints.Get(v.Name)
+		if err != nil {
+			return err
+		}
+		if next > version {
+			return nil
+		}
 
 		select {
-		case <-v.wakeUP:
+		case <-ctx.Done():
+			return WaitTimeoutError
+		case <-progress:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Build a shadow of a versioned view until it reaches stream head,
+// returns shadow view and next version to apply.
+func (v *View) buildShadow(ctx context.Context, target string, params map[string]string, dev bool) (*View, uint64, error) {
+	vv, ok := v.V.(VersionedViewer)
+	if !ok {
+		return nil, 0, errors.New("View " + v.Name + " is not versioned")
+	}
+
+	sv, err := vv.Shadow(target)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	shadow := NewView(v.Name+"@"+target, sv)
+	shadow.Store = v.Store
+	shadow.Checkpoints = v.Checkpoints
+	shadow.Retry = v.Retry
+
+	sv.Init(params, dev)
+	err = sv.Purge()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint64
+	stream := v.V.Stream()
+	for ctx.Err() == nil {
+		head, err := v.Store.Version(stream)
+		if err != nil {
+			return nil, 0, err
+		}
+		if head < next {
+			// caught up
+			return shadow, next, nil
+		}
+
+		next, err = shadow.catchUp(ctx, stream, next, head)
+		if err != nil {
+			return nil, 0, err
+		}
+		if head < next {
+			return shadow, next, nil
 		}
 	}
+	return nil, 0, ctx.Err()
+}
+
+// Next version to apply, from checkpoint or viewer status
+func (v *View) start() (uint64, error) {
+	next, err := v.Checkpoints.Get(v.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	// viewers tracking their own position
+	if next == 0 {
+		status, err := v.V.Status()
+		if err == nil && status > 0 {
+			next = status + 1
+		}
+	}
+	return next, nil
+}
+
+// Apply event with retries, once retries are exhausted failure mode is used
+func (v *View) apply(ctx context.Context, e Event) error {
+	var err error
+	wait := v.Retry.Backoff
+	for try := 0; try <= v.Retry.Retries; try++ {
+		if try > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+			wait *= 2
+			if v.Retry.MaxBackoff > 0 && wait > v.Retry.MaxBackoff {
+				wait = v.Retry.MaxBackoff
+			}
+		}
+
+		err = v.V.Apply(e)
+		if err == nil {
+			return nil
+		}
+		v.setLastError(err)
+		log.Println("Failed to apply event", e.EventID, "version", e.EventVersion, ":", err)
+	}
 
+	switch v.Retry.Mode {
+	case SkipMode:
+		log.Println("Skipping event", e.EventID)
+		return nil
+	case ParkMode:
+		log.Println("Parking event", e.EventID, "into", v.DeadLetterStream())
+		return v.park(e, err)
+	default:
+		return errors.New("View " + v.Name + " stopped at version " + strconv.FormatUint(e.EventVersion, 10) + ": " + err.Error())
+	}
+}
+
+// Last error applying events
+func (v *View) LastError() error {
+	v.errLock.Lock()
+	defer v.errLock.Unlock()
+	return v.lastErr
+}
+
+func (v *View) setLastError(err error) {
+	v.errLock.Lock()
+	v.lastErr = err
+	v.errLock.Unlock()
+}
+
+// Store failed event into dead-letter stream
+func (v *View) park(e Event, cause error) error {
+	data := make(map[string]interface{})
+	for k, d := range e.EventData {
+		data[k] = d
+	}
+	data[DeadLetterTypeKey] = e.EventType
+	data[DeadLetterOriginKey] = e.GetStream()
+	data[DeadLetterStreamKey] = e.EventStream
+	data[DeadLetterErrorKey] = cause.Error()
+	data[DeadLetterVersionKey] = e.EventVersion
+
+	dl := NewEvent("", ParkedEvent, data)
+	dl.Entity = DeadLetterEntity
+	dl.EntityID = v.Name
+	_, err := v.Store.Store(dl, StoreOptions{})
 	return err
 }
+
+// Release store scan if events were not read
+func drain(events chan Event) {
+	go func() {
+		for _ = range events {
+		}
+	}()
+}
@@ -0,0 +1,210 @@
+package gocqrs_test
+
+import (
+	"context"
+	"errors"
+	"github.com/diegogub/gocqrs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Viewer recording applied events, failing events of type fail
+type recorder struct {
+	lock   sync.Mutex
+	stream string
+	fail   string
+	events []gocqrs.Event
+}
+
+func (r *recorder) Init(params map[string]string, dev bool) {}
+
+func (r *recorder) Purge() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = nil
+	return nil
+}
+
+func (r *recorder) Status() (uint64, error) {
+	return 0, nil
+}
+
+func (r *recorder) Stream() string {
+	return r.stream
+}
+
+func (r *recorder) Rebuild() error {
+	return r.Purge()
+}
+
+func (r *recorder) Apply(e gocqrs.Event) error {
+	if e.EventType == r.fail {
+		return errors.New("failed " + e.EventType)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) applied() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.events)
+}
+
+// Run view until stream head is applied, then stop it
+func runView(t *testing.T, v *gocqrs.View) {
+	head, err := v.Store.Version(v.V.Stream())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- v.RunContext(ctx, "", map[string]string{}, false)
+	}()
+
+	wctx, wcancel := context.WithTimeout(ctx, time.Second*5)
+	err = v.WaitFor(wctx, v.V.Stream(), head)
+	wcancel()
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestViewCheckpoints(t *testing.T) {
+	app, store := newTestApp()
+	for _, id := range []string{"i1", "i2", "i3"} {
+		_, err := handle(app, "acc", "ItemsCreated", id, map[string]interface{}{"name": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &recorder{stream: gocqrs.CategoryStream("items")}
+	checkpoints := gocqrs.NewMemCheckpoints()
+	v := gocqrs.NewView("items", r)
+	v.Store = store
+	v.Checkpoints = checkpoints
+	runView(t, v)
+
+	if r.applied() != 3 {
+		t.Fatal("expected 3 applied events, got", r.applied())
+	}
+	next, _ := checkpoints.Get("items")
+	if next != 4 {
+		t.Fatal("checkpoint should be next version to apply, got", next)
+	}
+
+	// restarted view continues from checkpoint
+	_, err := handle(app, "acc", "ItemsUpdated", "i1", map[string]interface{}{"name": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v = gocqrs.NewView("items", r)
+	v.Store = store
+	v.Checkpoints = checkpoints
+	runView(t, v)
+
+	if r.applied() != 4 {
+		t.Fatal("expected only new event applied, got", r.applied())
+	}
+}
+
+func TestViewPark(t *testing.T) {
+	app, store := newTestApp()
+	for _, typ := range []string{"ItemsCreated", "ItemsUpdated", "ItemsUpdated"} {
+		_, err := handle(app, "acc", typ, "i1", map[string]interface{}{"name": typ})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := gocqrs.EventTypeStream("ItemsUpdated")
+	r := &recorder{stream: stream, fail: "ItemsUpdated"}
+	v := gocqrs.NewView("updates", r)
+	v.Store = store
+	v.Retry = gocqrs.RetryPolicy{Mode: gocqrs.ParkMode}
+	runView(t, v)
+
+	ch, parked := store.Range(v.DeadLetterStream())
+	if parked != 2 {
+		t.Fatal("expected 2 parked events, got", parked)
+	}
+	for e := range ch {
+		if e.GetType() != gocqrs.ParkedEvent || e.GetData()[gocqrs.DeadLetterTypeKey] != "ItemsUpdated" {
+			t.Fatal("unexpected dead letter", e.GetType(), e.GetData())
+		}
+	}
+
+	// dead letters are not read again by event type views
+	version, _ := store.Version(stream)
+	if version != 2 {
+		t.Fatal("dead letters linked into event type stream")
+	}
+}
+
+func TestViewManager(t *testing.T) {
+	app, store := newTestApp()
+	_, err := handle(app, "acc", "ItemsCreated", "i1", map[string]interface{}{"name": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := gocqrs.CategoryStream("items")
+	vm := gocqrs.NewViewManager(store)
+	err = vm.Add(gocqrs.NewView("items", &recorder{stream: stream}), map[string]string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err = vm.WaitFor(ctx, "items", stream, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = vm.Pause("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := vm.Status()
+	if len(status) != 1 || status[0].Running || !status[0].Paused || status[0].Lag != 0 {
+		t.Fatal("unexpected paused status", status)
+	}
+
+	err = vm.Resume("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status = vm.Status(); !status[0].Running {
+		t.Fatal("view not resumed", status)
+	}
+	vm.Stop()
+	if status = vm.Status(); status[0].Running {
+		t.Fatal("view not stopped", status)
+	}
+}
+
+func TestViewWaitForZero(t *testing.T) {
+	_, store := newTestApp()
+	stream := gocqrs.CategoryStream("items")
+	v := gocqrs.NewView("items", &recorder{stream: stream})
+	v.Store = store
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err := v.WaitFor(ctx, stream, 0)
+	if err != nil {
+		t.Fatal("waiting for version 0 should not block, got", err)
+	}
+}
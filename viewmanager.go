@@ -0,0 +1,398 @@
+package gocqrs
+
+import (
+	"context"
+	"errors"
+	"gopkg.in/gin-gonic/gin.v1"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	PauseViewOpt  = "pause"
+	ResumeViewOpt = "resume"
+)
+
+var (
+	InvalidViewError = errors.New("Invalid view")
+	AdminOnlyError   = errors.New("Only admin role allowed")
+)
+
+// Runs, monitors and controls app views
+type ViewManager struct {
+	// serializes start/stop operations
+	ctl   sync.Mutex
+	lock  sync.RWMutex
+	store EventStore
+	views map[string]*managedView
+}
+
+type managedView struct {
+	view   *View
+	params map[string]string
+	dev    bool
+
+	running bool
+	paused  bool
+	err     error
+	cancel  context.CancelFunc
+	done    chan bool
+
+	// blue/green rebuild in progress
+	rebuilding    bool
+	cancelRebuild context.CancelFunc
+}
+
+type ViewStatus struct {
+	Name   string `json:"name"`
+	Stream string `json:"stream"`
+	// last applied version
+	Position   uint64 `json:"position"`
+	Head       uint64 `json:"head"`
+	Lag        uint64 `json:"lag"`
+	Running    bool   `json:"running"`
+	Paused     bool   `json:"paused"`
+	Rebuilding bool   `json:"rebuilding"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+func NewViewManager(store EventStore) *ViewManager {
+	var vm ViewManager
+	vm.store = store
+	vm.views = make(map[string]*managedView)
+	return &vm
+}
+
+// Register view, started by Start or App.Run
+func (vm *ViewManager) Add(v *View, params map[string]string, dev bool) error {
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+
+	_, exist := vm.views[v.Name]
+	if exist {
+		return errors.New("View already added: " + v.Name)
+	}
+
+	if v.Store == nil {
+		v.Store = vm.store
+	}
+	vm.views[v.Name] = &managedView{view: v, params: params, dev: dev}
+	return nil
+}
+
+// Start every registered view not running or paused
+func (vm *ViewManager) Start() {
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+
+	for _, mv := range vm.views {
+		if !mv.running && !mv.paused {
+			vm.run(mv, "")
+		}
+	}
+}
+
+// Stop every view
+func (vm *ViewManager) Stop() {
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+
+	vm.lock.Lock()
+	stopped := make([]chan bool, 0)
+	for _, mv := range vm.views {
+		if mv.rebuilding {
+			mv.cancelRebuild()
+		}
+		stopped = append(stopped, vm.stop(mv))
+	}
+	vm.lock.Unlock()
+
+	for _, done := range stopped {
+		<-done
+	}
+}
+
+func (vm *ViewManager) Pause(name string) error {
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+
+	vm.lock.Lock()
+	mv, ok := vm.views[name]
+	if !ok {
+		vm.lock.Unlock()
+		return InvalidViewError
+	}
+	done := vm.stop(mv)
+	mv.paused = true
+	vm.lock.Unlock()
+
+	<-done
+	return nil
+}
+
+func (vm *ViewManager) Resume(name string) error {
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+
+	mv, ok := vm.views[name]
+	if !ok {
+		return InvalidViewError
+	}
+	mv.paused = false
+	if !mv.running {
+		vm.run(mv, "")
+	}
+	return nil
+}
+
+// Rebuild view. Versioned views are rebuilt into a shadow target in
+// background and swapped once they reach the stream head, other views
+// are stopped, purged and replayed.
+func (vm *ViewManager) Rebuild(name string) error {
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+
+	mv, ok := vm.views[name]
+	if !ok {
+		return InvalidViewError
+	}
+
+	if _, versioned := mv.view.V.(VersionedViewer); versioned {
+		if mv.rebuilding {
+			return errors.New("View already rebuilding: " + name)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		mv.rebuilding = true
+		mv.cancelRebuild = cancel
+		go vm.blueGreen(ctx, mv)
+		return nil
+	}
+
+	// view needs the lock to finish, ctl keeps other operations out
+	done := vm.stop(mv)
+	vm.lock.Unlock()
+	<-done
+	vm.lock.Lock()
+
+	mv.paused = false
+	vm.run(mv, RebuiltOpt)
+	return nil
+}
+
+// Build shadow target and swap it with live one
+func (vm *ViewManager) blueGreen(ctx context.Context, mv *managedView) {
+	target := mv.view.Name + "_" + strconv.FormatInt(time.Now().UTC().UnixNano(), 36)
+	shadow, next, err := mv.view.buildShadow(ctx, target, mv.params, mv.dev)
+	// shadow position is only used while building
+	defer vm.deleteCheckpoint(mv.view, mv.view.Name+"@"+target)
+
+	vm.ctl.Lock()
+	defer vm.ctl.Unlock()
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	mv.rebuilding = false
+	if err != nil {
+		mv.err = err
+		return
+	}
+
+	// live view stops updating, shadow applies last events and takes its place
+	wasRunning := mv.running
+	done := vm.stop(mv)
+	vm.lock.Unlock()
+	<-done
+	vm.lock.Lock()
+
+	stream := mv.view.V.Stream()
+	head, err := mv.view.Store.Version(stream)
+	if err == nil && head >= next {
+		next, err = shadow.catchUp(ctx, stream, next, head)
+	}
+	// cancelled rebuilds are not promoted, shadow may not be caught up
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = shadow.V.(VersionedViewer).Promote()
+	}
+	if err == nil {
+		err = mv.view.Checkpoints.Save(mv.view.Name, next)
+	}
+
+	if err == nil {
+		mv.view.V = shadow.V
+	}
+
+	if wasRunning && !mv.paused {
+		vm.run(mv, "")
+	}
+	mv.err = err
+}
+
+func (vm *ViewManager) deleteCheckpoint(v *View, name string) {
+	err := v.Checkpoints.Delete(name)
+	if err != nil {
+		log.Println("Failed to delete checkpoint", name, ":", err)
+	}
+}
+
+// run view in background, lock should be held
+func (vm *ViewManager) run(mv *managedView, action string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mv.cancel = cancel
+	mv.done = make(chan bool)
+	mv.running = true
+	mv.err = nil
+
+	go func(done chan bool) {
+		err := mv.view.RunContext(ctx, action, mv.params, mv.dev)
+
+		vm.lock.Lock()
+		if done == mv.done {
+			mv.running = false
+			mv.err = err
+		}
+		vm.lock.Unlock()
+		close(done)
+	}(mv.done)
+}
+
+// stop view, returned channel is closed once it finished. Lock should
+// be held, running view needs it to finish so it can't be waited holding it.
+func (vm *ViewManager) stop(mv *managedView) chan bool {
+	if !mv.running {
+		done := make(chan bool)
+		close(done)
+		return done
+	}
+	mv.cancel()
+	mv.running = false
+	return mv.done
+}
+
+// Block until view applied version of stream
+func (vm *ViewManager) WaitFor(ctx context.Context, name, stream string, version uint64) error {
+	vm.lock.RLock()
+	mv, ok := vm.views[name]
+	vm.lock.RUnlock()
+	if !ok {
+		return InvalidViewError
+	}
+	return mv.view.WaitFor(ctx, stream, version)
+}
+
+func (vm *ViewManager) Status() []ViewStatus {
+	type viewState struct {
+		view *View
+		err  error
+	}
+
+	// store is not read holding the lock
+	vm.lock.RLock()
+	status := make([]ViewStatus, 0)
+	states := make([]viewState, 0)
+	for name, mv := range vm.views {
+		var vs ViewStatus
+		vs.Name = name
+		vs.Stream = mv.view.V.Stream()
+		vs.Running = mv.running
+		vs.Paused = mv.paused
+		vs.Rebuilding = mv.rebuilding
+		status = append(status, vs)
+		states = append(states, viewState{mv.view, mv.err})
+	}
+	vm.lock.RUnlock()
+
+	for i, state := range states {
+		vs := &status[i]
+		next, _ := state.view.Checkpoints.Get(vs.Name)
+		if next > 0 {
+			vs.Position = next - 1
+		}
+		vs.Head, _ = state.view.Store.Version(vs.Stream)
+		if vs.Head > vs.Position {
+			vs.Lag = vs.Head - vs.Position
+		}
+
+		if state.err != nil {
+			vs.LastError = state.err.Error()
+		} else if err := state.view.LastError(); err != nil {
+			vs.LastError = err.Error()
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
+
+// Register view into app view manager
+func (app *App) AddView(v *View, params map[string]string, dev bool) {
+	err := app.Views.Add(v, params, dev)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Only admin role can manage views, roles, reservations and references
+func (app *App) authAdmin(c *gin.Context) error {
+	if app.AuthOff {
+		return nil
+	}
+
+	claims, err := app.getSession(c)
+	if err != nil || claims == nil {
+		return AdminOnlyError
+	}
+
+	if app.AdminRole == "" || claims.Role != app.AdminRole {
+		return AdminOnlyError
+	}
+	return nil
+}
+
+func ViewsHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(200, runningApp.Views.Status())
+}
+
+func ViewActionHandler(c *gin.Context) {
+	err := runningApp.authAdmin(c)
+	if err != nil {
+		c.JSON(401, map[string]string{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	switch c.Param("action") {
+	case RebuiltOpt:
+		err = runningApp.Views.Rebuild(name)
+	case PauseViewOpt:
+		err = runningApp.Views.Pause(name)
+	case ResumeViewOpt:
+		err = runningApp.Views.Resume(name)
+	default:
+		c.JSON(400, map[string]string{"error": "Invalid view action"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(404, map[string]string{"error": err.Error()})
+		return
+	}
+	c.JSON(200, map[string]string{"view": name, "action": c.Param("action")})
+}